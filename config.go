@@ -1,6 +1,13 @@
 package ezconfig
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/explodes/ezconfig/backoff"
+)
 
 // Db Config is configuration in the following format:
 //   [database]
@@ -13,8 +20,43 @@ import "fmt"
 //   ssl = "disable"
 //   max_connections = 10
 //
+//   [database.params]
+//   application_name = "my-service"
+//   connect_timeout = "5"
+//
+//   [database.tls]
+//   ca_file = "/etc/ssl/db/ca.pem"
+//   cert_file = "/etc/ssl/db/client.pem"
+//   key_file = "/etc/ssl/db/client.key"
+//   server_name = "db.internal"
+//
+// sqlite3 databases have additional settings:
+//   [database.sqlite]
+//   journal_mode = "WAL"
+//   busy_timeout = 5000
+//   foreign_keys = true
+//   cache = "shared"
+//
+//   [database.sqlite.pragmas]
+//   synchronous = "NORMAL"
+//
+// Connection attempts can be configured, see RetryConfig:
+//   [database.retry]
+//   attempts = 10
+//   backoff = "constant"
+//   wait = "1s"
+//
 type DbConfig struct {
 	Database DbHost
+
+	// Logger receives the warnings of the database driver, slog.Default() if nil.
+	// The opener sets it to its own logger.
+	Logger *slog.Logger `toml:"-"`
+}
+
+// Log returns Logger, or slog.Default() if it is not set
+func (c *DbConfig) Log() *slog.Logger {
+	return logOrDefault(c.Logger)
 }
 
 type DbHost struct {
@@ -25,7 +67,26 @@ type DbHost struct {
 	User           string
 	Password       string
 	Ssl            string
-	MaxConnections int `toml:"max_connections"`
+	MaxConnections int               `toml:"max_connections"`
+	Params         map[string]string // extra driver parameters, see registry.AllowParams
+	TLS            DbTLS             `toml:"tls"`
+	Sqlite         SqliteOptions     `toml:"sqlite"`
+	Retry          RetryConfig       `toml:"retry"`
+}
+
+// DbTLS holds the certificates used to secure a database connection
+// with ssl = "require", "verify-ca" or "verify-full". A ca_file makes "require"
+// verify the certificate chain as "verify-ca" does.
+type DbTLS struct {
+	CAFile     string `toml:"ca_file"`     // PEM encoded certificate authorities to trust
+	CertFile   string `toml:"cert_file"`   // PEM encoded client certificate
+	KeyFile    string `toml:"key_file"`    // PEM encoded client key
+	ServerName string `toml:"server_name"` // name to verify the server certificate against, defaults to the host
+}
+
+// Enabled reports whether any TLS settings were configured
+func (t *DbTLS) Enabled() bool {
+	return t.CAFile != "" || t.CertFile != "" || t.KeyFile != "" || t.ServerName != ""
 }
 
 // Address builds a host:port string
@@ -33,10 +94,47 @@ func (b *DbHost) Address() string {
 	return fmt.Sprintf("%s:%d", b.Host, b.Port)
 }
 
+// SqliteOptions holds settings that only apply to sqlite3 databases
+type SqliteOptions struct {
+	JournalMode string            `toml:"journal_mode"` // DELETE, TRUNCATE, PERSIST, MEMORY, WAL or OFF
+	BusyTimeout int               `toml:"busy_timeout"` // milliseconds to wait for a locked database
+	ForeignKeys bool              `toml:"foreign_keys"`
+	Cache       string            // "shared" or "private"
+	Pragmas     map[string]string // executed on every new connection
+}
+
 // ProducerConfig is config in the following format:
 //   [producer]
 //   type = "dummy"
 //   retries = 5
+//   mode = "sync"
+//
+//   [producer.kafka]
+//   client_id = "my-service"
+//   version = "2.8.0"
+//   compression = "zstd"
+//   idempotent = true
+//   partitioner = "hash"
+//
+// See KafkaOptions for every kafka setting.
+// Connections can be secured and authenticated, see TLSConfig and SASLConfig:
+//
+//   [producer.tls]
+//   ca_file = "/etc/kafka/ca.pem"
+//
+//   [producer.sasl]
+//   mechanism = "SCRAM-SHA-512"
+//   username = "my-service"
+//   password = "secret"
+//
+// Connection attempts can be configured, see RetryConfig:
+//
+//   [producer.retry]
+//   attempts = 30
+//   backoff = "exponential"
+//   initial = "100ms"
+//   max = "10s"
+//   factor = 2
 //
 //   [[producers]]
 //   host = "docker.loc"
@@ -44,19 +142,312 @@ func (b *DbHost) Address() string {
 type ProducerConfig struct {
 	Settings ProducerSettings `toml:"producer"`
 	Hosts    []ProducerHost   `toml:"producers"`
+
+	// Logger receives the warnings of the producer and the messages it could not
+	// publish, slog.Default() if nil. The opener sets it to its own logger.
+	Logger *slog.Logger `toml:"-"`
+}
+
+// Log returns Logger, or slog.Default() if it is not set
+func (c *ProducerConfig) Log() *slog.Logger {
+	return logOrDefault(c.Logger)
 }
 
 type ProducerSettings struct {
-	Type    string // "kafka" or "dummy"
-	Retries int
+	Type    string // "kafka", "amqp" or "dummy"
+	Retries int    // retries made by the producer when publishing
+	Mode    string // "async" (default) to return once a message is queued, or "sync" to wait for each to be acknowledged
+	Retry   RetryConfig
+	Kafka   KafkaOptions `toml:"kafka"`
+	TLS     TLSConfig    `toml:"tls"`
+	SASL    SASLConfig   `toml:"sasl"`
+	AMQP    AMQPOptions  `toml:"amqp"`
+}
+
+const (
+	// ProducerModeSync producers wait for each message to be acknowledged when publishing
+	ProducerModeSync = "sync"
+	// ProducerModeAsync producers return once a message is queued, and report its outcome later
+	ProducerModeAsync = "async"
+)
+
+// Async reports whether the producer is in async mode, the default
+func (s *ProducerSettings) Async() bool {
+	return s.Mode != ProducerModeSync
+}
+
+// ValidateMode makes sure the mode is "sync", "async" or not set
+func (s *ProducerSettings) ValidateMode() error {
+	switch s.Mode {
+	case "", ProducerModeSync, ProducerModeAsync:
+		return nil
+	default:
+		return fmt.Errorf("Invalid producer mode %q, expected %q or %q", s.Mode, ProducerModeSync, ProducerModeAsync)
+	}
 }
 
-type ProducerHost struct {
+// BrokerHost is a host producers and consumers connect to
+type BrokerHost struct {
 	Host string
 	Port int
 }
 
+// ProducerHost is a host producers connect to
+type ProducerHost = BrokerHost
+
 // Address builds a host:port string
-func (b *ProducerHost) Address() string {
+func (b *BrokerHost) Address() string {
 	return fmt.Sprintf("%s:%d", b.Host, b.Port)
 }
+
+// TLSConfig holds the certificates used to secure producer and consumer connections
+type TLSConfig struct {
+	Enable     bool   // use TLS with the system certificate authorities, implied by any other setting
+	CAFile     string `toml:"ca_file"`     // PEM encoded certificate authorities to trust
+	CertFile   string `toml:"cert_file"`   // PEM encoded client certificate
+	KeyFile    string `toml:"key_file"`    // PEM encoded client key
+	ServerName string `toml:"server_name"` // name to verify the server certificates against, defaults to each host
+	SkipVerify bool   `toml:"skip_verify"` // do not verify the server certificates, for testing only
+}
+
+// Enabled reports whether any TLS settings were configured
+func (t *TLSConfig) Enabled() bool {
+	return t.Enable || t.CAFile != "" || t.CertFile != "" || t.KeyFile != "" || t.ServerName != "" || t.SkipVerify
+}
+
+// SASLConfig holds the credentials used to authenticate kafka producer and consumer connections.
+// The password is masked when the settings are printed or logged.
+type SASLConfig struct {
+	Mechanism string // "PLAIN" (default), "SCRAM-SHA-256" or "SCRAM-SHA-512"
+	Username  string
+	Password  string
+}
+
+// Enabled reports whether any SASL settings were configured
+func (s *SASLConfig) Enabled() bool {
+	return s.Mechanism != "" || s.Username != "" || s.Password != ""
+}
+
+// String describes the settings without the password
+func (s SASLConfig) String() string {
+	return fmt.Sprintf("{Mechanism:%s Username:%s Password:%s}", s.Mechanism, s.Username, maskPassword(s.Password))
+}
+
+// LogValue logs the settings without the password
+func (s SASLConfig) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("mechanism", s.Mechanism),
+		slog.String("username", s.Username),
+		slog.String("password", maskPassword(s.Password)))
+}
+
+// logOrDefault returns logger, or slog.Default() if it is nil
+func logOrDefault(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
+
+// maskPassword hides a password, if one is set
+func maskPassword(password string) string {
+	if password == "" {
+		return ""
+	}
+	return "xxxxx"
+}
+
+// KafkaOptions holds settings that only apply to kafka producers.
+// Settings that are not set keep the defaults of the kafka client.
+//   [producer.kafka]
+//   client_id = "my-service"
+//   version = "2.8.0"
+//   acks = "all"
+//   compression = "snappy"
+//   compression_level = 6
+//   idempotent = true
+//   max_message_bytes = 1000000
+//   flush_frequency = "10ms"
+//   flush_bytes = 65536
+//   flush_messages = 100
+//   partitioner = "hash"
+//   retry_backoff = "100ms"
+//   timeout = "10s"
+//   dial_timeout = "30s"
+//   read_timeout = "30s"
+//   write_timeout = "30s"
+type KafkaOptions struct {
+	ClientID         string        `toml:"client_id"` // identifies the service to the brokers
+	Version          string        // version of the brokers, such as "2.8.0", to use newer protocol features
+	Acks             string        // "all" (default), "leader" or "none", the replicas that must acknowledge a message
+	Compression      string        // "none", "gzip", "snappy", "lz4" or "zstd"
+	CompressionLevel *int          `toml:"compression_level"` // codec specific, only gzip and zstd support levels
+	Idempotent       bool          // publish every message exactly once per partition, requires version 0.11.0 or later
+	MaxMessageBytes  int           `toml:"max_message_bytes"` // largest message accepted, should match the brokers' message.max.bytes
+	FlushFrequency   time.Duration `toml:"flush_frequency"`   // how long messages are buffered before being sent in a batch
+	FlushBytes       int           `toml:"flush_bytes"`       // size of the buffered messages that triggers a batch
+	FlushMessages    int           `toml:"flush_messages"`    // number of buffered messages that triggers a batch
+	Partitioner      string        // "hash" (default) by key, "random", or "manual" to require Message.Partition
+	RetryBackoff     time.Duration `toml:"retry_backoff"` // how long to wait before retrying to publish a message
+	Timeout          time.Duration // how long the brokers are given to acknowledge a message
+	DialTimeout      time.Duration `toml:"dial_timeout"`
+	ReadTimeout      time.Duration `toml:"read_timeout"`
+	WriteTimeout     time.Duration `toml:"write_timeout"`
+}
+
+// AMQPOptions holds settings that only apply to amqp producers, such as RabbitMQ.
+// Connections are secured by [producer.tls]. The password is masked when the
+// settings are printed or logged.
+//   [producer.amqp]
+//   vhost = "/orders"
+//   username = "my-service"
+//   password = "secret"
+//   exchange = "events"
+//   confirm = true
+//   persistent = true
+//   heartbeat = "10s"
+//   dial_timeout = "30s"
+type AMQPOptions struct {
+	VHost       string        `toml:"vhost"` // namespace of the exchanges, "/" by default
+	Username    string        // authenticates with PLAIN, the broker's default guest user if empty
+	Password    string        // sent in clear text unless [producer.tls] is set
+	Exchange    string        // exchange of the topics that do not name one, the default exchange if empty
+	Confirm     bool          // have the broker confirm each message reached its exchange, required to report failures
+	Persistent  bool          // messages are written to disk, surviving restarts of the broker
+	Heartbeat   time.Duration // how often heartbeats are sent, the broker's interval by default
+	DialTimeout time.Duration `toml:"dial_timeout"`
+}
+
+// String describes the settings without the password
+func (o AMQPOptions) String() string {
+	return fmt.Sprintf("{VHost:%s Username:%s Password:%s Exchange:%s Confirm:%t Persistent:%t Heartbeat:%s DialTimeout:%s}",
+		o.VHost, o.Username, maskPassword(o.Password), o.Exchange, o.Confirm, o.Persistent, o.Heartbeat, o.DialTimeout)
+}
+
+// LogValue logs the settings without the password
+func (o AMQPOptions) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("vhost", o.VHost),
+		slog.String("username", o.Username),
+		slog.String("password", maskPassword(o.Password)),
+		slog.String("exchange", o.Exchange),
+		slog.Bool("confirm", o.Confirm),
+		slog.Bool("persistent", o.Persistent),
+		slog.Duration("heartbeat", o.Heartbeat),
+		slog.Duration("dial_timeout", o.DialTimeout))
+}
+
+// ConsumerConfig is config in the following format:
+//   [consumer]
+//   type = "kafka"
+//   group = "my-service"
+//
+//   [consumer.kafka]
+//   client_id = "my-service"
+//   version = "2.8.0"
+//   initial_offset = "oldest"
+//   commit_interval = "1s"
+//
+//   [[consumers]]
+//   host = "docker.loc"
+//   port = 9092
+//
+// See KafkaConsumerOptions for every kafka setting. Connections are secured and
+// attempts configured as they are for producers, in [consumer.tls], [consumer.sasl]
+// and [consumer.retry].
+type ConsumerConfig struct {
+	Settings ConsumerSettings `toml:"consumer"`
+	Hosts    []BrokerHost     `toml:"consumers"`
+
+	// Logger receives the warnings of the consumer, slog.Default() if nil.
+	// The opener sets it to its own logger.
+	Logger *slog.Logger `toml:"-"`
+}
+
+// Log returns Logger, or slog.Default() if it is not set
+func (c *ConsumerConfig) Log() *slog.Logger {
+	return logOrDefault(c.Logger)
+}
+
+type ConsumerSettings struct {
+	Type  string // "kafka" or "dummy"
+	Group string // consumers of the same group share the messages of the topics they subscribe to
+	Retry RetryConfig
+	Kafka KafkaConsumerOptions `toml:"kafka"`
+	TLS   TLSConfig            `toml:"tls"`
+	SASL  SASLConfig           `toml:"sasl"`
+}
+
+// KafkaConsumerOptions holds settings that only apply to kafka consumers.
+// Settings that are not set keep the defaults of the kafka client.
+//   [consumer.kafka]
+//   client_id = "my-service"
+//   version = "2.8.0"
+//   initial_offset = "newest"
+//   commit_interval = "1s"
+//   rebalance = "sticky"
+//   session_timeout = "10s"
+//   heartbeat_interval = "3s"
+//   max_processing_time = "100ms"
+//   dial_timeout = "30s"
+//   read_timeout = "30s"
+//   write_timeout = "30s"
+type KafkaConsumerOptions struct {
+	ClientID          string        `toml:"client_id"` // identifies the service to the brokers
+	Version           string        // version of the brokers, such as "2.8.0", to use newer protocol features
+	InitialOffset     string        `toml:"initial_offset"`  // "newest" (default) or "oldest", where groups without committed offsets start
+	CommitInterval    time.Duration `toml:"commit_interval"` // how often the offsets of handled messages are committed
+	Rebalance         string        // "range" (default), "roundrobin" or "sticky", how partitions are shared within the group
+	SessionTimeout    time.Duration `toml:"session_timeout"`     // how long the brokers wait for a heartbeat before removing a consumer from the group
+	HeartbeatInterval time.Duration `toml:"heartbeat_interval"`  // how often heartbeats are sent, less than a third of session_timeout
+	MaxProcessingTime time.Duration `toml:"max_processing_time"` // how long a message may be handled before fetching is paused
+	DialTimeout       time.Duration `toml:"dial_timeout"`
+	ReadTimeout       time.Duration `toml:"read_timeout"`
+	WriteTimeout      time.Duration `toml:"write_timeout"`
+}
+
+// RetryConfig describes how many attempts to make when connecting to a service
+// and how long to wait between them. Durations are strings such as "500ms" or "2s".
+// The backoff is "constant", waiting wait between attempts, or "exponential",
+// waiting min(max, initial * factor^attempt).
+type RetryConfig struct {
+	Attempts int
+	Backoff  string
+	Wait     time.Duration
+	Initial  time.Duration
+	Max      time.Duration
+	Factor   float64
+}
+
+// Enabled reports whether retries were configured
+func (r *RetryConfig) Enabled() bool {
+	return r.Attempts > 0
+}
+
+// Strategy builds the configured backoff strategy
+func (r *RetryConfig) Strategy() (backoff.Strategy, error) {
+	switch r.Backoff {
+	case "", "constant":
+		if r.Wait < 0 {
+			return nil, errors.New("Retry wait must not be negative")
+		}
+		return backoff.Constant(r.Wait), nil
+	case "exponential":
+		factor := r.Factor
+		if factor == 0 {
+			factor = 2
+		}
+		if r.Initial <= 0 {
+			return nil, errors.New("Retry initial must be positive for exponential backoff")
+		}
+		if r.Max < r.Initial {
+			return nil, errors.New("Retry max must be at least initial for exponential backoff")
+		}
+		if factor < 1 {
+			return nil, errors.New("Retry factor must be at least 1 for exponential backoff")
+		}
+		return backoff.Exponential(r.Initial, r.Max, factor), nil
+	default:
+		return nil, fmt.Errorf("Invalid retry backoff %q, expected constant or exponential", r.Backoff)
+	}
+}
@@ -0,0 +1,61 @@
+package consumer
+
+import (
+	"context"
+	"time"
+)
+
+// Message is a message received from the service which backs a Consumer
+type Message struct {
+	// Topic is where the message was published to
+	Topic string
+
+	// Key optionally identifies the entity the message is about
+	Key []byte
+
+	// Value is the payload of the message
+	Value []byte
+
+	// Headers are the metadata sent along with the message
+	Headers map[string][]byte
+
+	// Timestamp is when the message was created or published
+	Timestamp time.Time
+
+	// Partition and Offset are where the message is stored,
+	// for services that have partitions
+	Partition int32
+	Offset    int64
+}
+
+// Handler processes a message. Returning nil commits the message, so that it
+// is not received again by the consumer's group. Returning an error stops the
+// subscription without committing the message, which is received again by the
+// next subscription of the group. ctx is done when the subscription stops, or
+// when the message was given to another consumer of the group.
+type Handler func(ctx context.Context, msg *Message) error
+
+// Consumer is capable of receiving the messages published to the service which backs it.
+// Messages are delivered at least once: a message may be received again when a consumer
+// stops before it is committed.
+type Consumer interface {
+
+	// Subscribe calls handler with the messages published to the topics until ctx is
+	// done, the handler returns an error, or the consumer is closed. Messages of a
+	// partition are handled one at a time, in order, but messages of different
+	// partitions may be handled concurrently.
+	// It returns nil when ctx is done, and otherwise the error that stopped it.
+	Subscribe(ctx context.Context, topics []string, handler Handler) error
+
+	// Close will commit handled messages, stop subscriptions and terminate
+	// the connection to the service backing this consumer
+	Close() error
+}
+
+// Checker is implemented by consumers that can check the health of their
+// connection to the service which backs them
+type Checker interface {
+
+	// Check returns an error if the service cannot be reached
+	Check(ctx context.Context) error
+}
@@ -0,0 +1,183 @@
+package dummy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/explodes/ezconfig"
+	"github.com/explodes/ezconfig/consumer"
+	"github.com/explodes/ezconfig/consumer/registry"
+)
+
+const (
+	// dummyConsumerType is the value to use in configuration to connect to this consumer type
+	dummyConsumerType = "dummy"
+)
+
+// errClosed is returned when subscribing with a closed consumer
+var errClosed = errors.New("Consumer is closed")
+
+// init registers the init and validation functions with the registry
+func init() {
+	registry.Register(dummyConsumerType, initConsumer, validateConfig)
+}
+
+// validateConfig makes sure all the required settings are present for the consumer
+func validateConfig(conf *ezconfig.ConsumerConfig) error {
+	return nil
+}
+
+// initConsumer creates a consumer of the in-memory topics, in the configured group
+func initConsumer(conf *ezconfig.ConsumerConfig) (consumer.Consumer, error) {
+	return &dummyConsumer{group: join(conf.Settings.Group), closed: make(chan struct{})}, nil
+}
+
+// broker holds the in-memory topics of the dummy consumer groups of the process
+var broker = struct {
+	mu      sync.Mutex
+	groups  map[string]*group
+	offsets map[string]int64
+}{
+	groups:  make(map[string]*group),
+	offsets: make(map[string]int64),
+}
+
+// group holds the messages its consumers have not consumed yet, by topic.
+// A group exists while any of its consumers is open.
+type group struct {
+	consumers int
+	pending   map[string][]*consumer.Message
+
+	// sent is closed, and replaced, whenever a message is queued
+	sent chan struct{}
+}
+
+// join adds a consumer to the named group, creating the group if needed
+func join(name string) *group {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	g, ok := broker.groups[name]
+	if !ok {
+		g = &group{pending: make(map[string][]*consumer.Message), sent: make(chan struct{})}
+		broker.groups[name] = g
+	}
+	g.consumers++
+	return g
+}
+
+// leave removes a consumer from its group, dropping the group and its
+// messages once it has no consumers left
+func leave(g *group) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	g.consumers--
+	if g.consumers == 0 {
+		for name, existing := range broker.groups {
+			if existing == g {
+				delete(broker.groups, name)
+			}
+		}
+	}
+}
+
+// Send queues a message for the open groups of dummy consumers, as if it were published.
+// Each group receives the message once, by one of its consumers, and groups that are
+// opened later do not receive it. The offset, and the timestamp when it is not set,
+// are filled in.
+func Send(msg *consumer.Message) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	msg.Offset = broker.offsets[msg.Topic]
+	broker.offsets[msg.Topic]++
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+	for _, g := range broker.groups {
+		received := *msg
+		g.queue(&received, false)
+	}
+}
+
+// queue adds a message to its topic, first if it is being received again.
+// broker.mu must be held.
+func (g *group) queue(msg *consumer.Message, first bool) {
+	if first {
+		g.pending[msg.Topic] = append([]*consumer.Message{msg}, g.pending[msg.Topic]...)
+	} else {
+		g.pending[msg.Topic] = append(g.pending[msg.Topic], msg)
+	}
+	close(g.sent)
+	g.sent = make(chan struct{})
+}
+
+// receive takes the next message of the first topic that has one, or returns
+// a channel closed when a message is queued
+func (g *group) receive(topics []string) (*consumer.Message, <-chan struct{}) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	for _, name := range topics {
+		if pending := g.pending[name]; len(pending) > 0 {
+			g.pending[name] = pending[1:]
+			return pending[0], nil
+		}
+	}
+	return nil, g.sent
+}
+
+// requeue puts back a message that was not committed, so that it is received first
+func (g *group) requeue(msg *consumer.Message) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	g.queue(msg, true)
+}
+
+// dummyConsumer is a stand-in consumer that receives the messages given to Send
+type dummyConsumer struct {
+	group     *group
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Subscribe calls handler with the messages sent to the topics until ctx is done,
+// the handler fails or the consumer is closed. A message the handler fails is
+// received again by the next subscription of the group.
+func (d *dummyConsumer) Subscribe(ctx context.Context, topics []string, handler consumer.Handler) error {
+	for {
+		select {
+		case <-d.closed:
+			return errClosed
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+		msg, sent := d.group.receive(topics)
+		if msg == nil {
+			select {
+			case <-sent:
+			case <-d.closed:
+			case <-ctx.Done():
+			}
+			continue
+		}
+		if err := handler(ctx, msg); err != nil {
+			d.group.requeue(msg)
+			return err
+		}
+	}
+}
+
+// Check always succeeds for dummyConsumers
+func (d *dummyConsumer) Check(ctx context.Context) error {
+	return nil
+}
+
+// Close stops the subscriptions of the consumer and leaves its group
+func (d *dummyConsumer) Close() error {
+	d.closeOnce.Do(func() {
+		close(d.closed)
+		leave(d.group)
+	})
+	return nil
+}
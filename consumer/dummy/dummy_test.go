@@ -0,0 +1,162 @@
+package dummy
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/explodes/ezconfig"
+	"github.com/explodes/ezconfig/consumer"
+	"github.com/explodes/ezconfig/consumer/registry"
+)
+
+func TestDetermineFactory(t *testing.T) {
+	factory, ok := registry.Get(dummyConsumerType)
+	if !ok {
+		t.Fatal("Dummy factory not registered")
+	}
+	sf1 := reflect.ValueOf(initConsumer)
+	sf2 := reflect.ValueOf(factory.Init)
+	if sf1.Pointer() != sf2.Pointer() {
+		t.Fatal("Unexpected init function")
+	}
+	sf1 = reflect.ValueOf(validateConfig)
+	sf2 = reflect.ValueOf(factory.Validate)
+	if sf1.Pointer() != sf2.Pointer() {
+		t.Fatal("Unexpected validate function")
+	}
+}
+
+// newConsumer creates a dummy consumer, closed when the test ends
+func newConsumer(t *testing.T) consumer.Consumer {
+	return newGroupConsumer(t, "")
+}
+
+// newGroupConsumer creates a dummy consumer in the named group, closed when the test ends
+func newGroupConsumer(t *testing.T, group string) consumer.Consumer {
+	conf := &ezconfig.ConsumerConfig{Settings: ezconfig.ConsumerSettings{Group: group}}
+	c, err := initConsumer(conf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestDummyConsumer_Subscribe(t *testing.T) {
+	c := newConsumer(t)
+	Send(&consumer.Message{Topic: "dummy_subscribe", Value: []byte("first")})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	received := make(chan *consumer.Message)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Subscribe(ctx, []string{"dummy_subscribe"}, func(ctx context.Context, msg *consumer.Message) error {
+			received <- msg
+			return nil
+		})
+	}()
+
+	// messages sent before and after subscribing are received in order
+	Send(&consumer.Message{Topic: "dummy_subscribe", Value: []byte("second")})
+	var first int64
+	for i, expected := range []string{"first", "second"} {
+		msg := <-received
+		if i == 0 {
+			first = msg.Offset
+		}
+		if string(msg.Value) != expected || msg.Offset != first+int64(i) || msg.Timestamp.IsZero() {
+			t.Fatalf("Unexpected message %d: %+v", i, msg)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestDummyConsumer_handlerError(t *testing.T) {
+	c := newConsumer(t)
+	Send(&consumer.Message{Topic: "dummy_error", Value: []byte("poison")})
+
+	errHandler := errors.New("handler failed")
+	err := c.Subscribe(context.Background(), []string{"dummy_error"}, func(ctx context.Context, msg *consumer.Message) error {
+		return errHandler
+	})
+	if err != errHandler {
+		t.Fatalf("Expected the handler error, got %v", err)
+	}
+
+	// the message was not committed, so the next subscription receives it again
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err = c.Subscribe(ctx, []string{"dummy_error"}, func(ctx context.Context, msg *consumer.Message) error {
+		if string(msg.Value) != "poison" {
+			t.Errorf("Unexpected message %q", msg.Value)
+		}
+		cancel()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestDummyConsumer_Close(t *testing.T) {
+	c := newConsumer(t)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Subscribe(context.Background(), []string{"dummy_close"}, func(ctx context.Context, msg *consumer.Message) error {
+			return nil
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	c.Close()
+	if err := <-done; err != errClosed {
+		t.Fatalf("Expected a closed error, got %v", err)
+	}
+}
+
+// receiveOne subscribes until a message is received, or returns nil if none is pending
+func receiveOne(t *testing.T, c consumer.Consumer, topic string) *consumer.Message {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	var received *consumer.Message
+	err := c.Subscribe(ctx, []string{topic}, func(ctx context.Context, msg *consumer.Message) error {
+		received = msg
+		cancel()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return received
+}
+
+func TestDummyConsumer_groups(t *testing.T) {
+	billing := newGroupConsumer(t, "billing")
+	audit := newGroupConsumer(t, "audit")
+	Send(&consumer.Message{Topic: "dummy_groups", Value: []byte("hello")})
+
+	// every group receives the message once
+	for _, c := range []consumer.Consumer{billing, audit} {
+		if msg := receiveOne(t, c, "dummy_groups"); msg == nil || string(msg.Value) != "hello" {
+			t.Fatalf("Unexpected message %+v", msg)
+		}
+	}
+	if msg := receiveOne(t, billing, "dummy_groups"); msg != nil {
+		t.Fatalf("Message received twice by a group: %+v", msg)
+	}
+
+	// messages of a group that was closed are not received by later consumers
+	Send(&consumer.Message{Topic: "dummy_groups", Value: []byte("unread")})
+	billing.Close()
+	if msg := receiveOne(t, newGroupConsumer(t, "billing"), "dummy_groups"); msg != nil {
+		t.Fatalf("Unexpected message from a closed group %+v", msg)
+	}
+}
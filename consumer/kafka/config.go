@@ -0,0 +1,113 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/explodes/ezconfig"
+	"github.com/explodes/ezconfig/internal/kafkaauth"
+)
+
+// initialOffsets are the accepted values for initial_offset
+var initialOffsets = map[string]int64{
+	"newest": sarama.OffsetNewest,
+	"oldest": sarama.OffsetOldest,
+}
+
+// rebalanceStrategies are the accepted values for rebalance
+var rebalanceStrategies = map[string]sarama.BalanceStrategy{
+	"range":      sarama.BalanceStrategyRange,
+	"roundrobin": sarama.BalanceStrategyRoundRobin,
+	"sticky":     sarama.BalanceStrategySticky,
+}
+
+// newConfig builds the sarama configuration of a consumer, and makes sure it is valid
+func newConfig(conf *ezconfig.ConsumerConfig) (*sarama.Config, error) {
+	options := conf.Settings.Kafka
+	if err := validateOptions(conf); err != nil {
+		return nil, err
+	}
+
+	config := sarama.NewConfig()
+	if options.ClientID != "" {
+		config.ClientID = options.ClientID
+	}
+	if options.Version != "" {
+		version, err := sarama.ParseKafkaVersion(options.Version)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid kafka version %q: %w", options.Version, err)
+		}
+		config.Version = version
+	}
+	if options.InitialOffset != "" {
+		config.Consumer.Offsets.Initial = initialOffsets[options.InitialOffset]
+	}
+	if options.CommitInterval > 0 {
+		config.Consumer.Offsets.AutoCommit.Interval = options.CommitInterval
+	}
+	if options.Rebalance != "" {
+		config.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{rebalanceStrategies[options.Rebalance]}
+	}
+	if options.SessionTimeout > 0 {
+		config.Consumer.Group.Session.Timeout = options.SessionTimeout
+	}
+	if options.HeartbeatInterval > 0 {
+		config.Consumer.Group.Heartbeat.Interval = options.HeartbeatInterval
+	}
+	if options.MaxProcessingTime > 0 {
+		config.Consumer.MaxProcessingTime = options.MaxProcessingTime
+	}
+	if options.DialTimeout > 0 {
+		config.Net.DialTimeout = options.DialTimeout
+	}
+	if options.ReadTimeout > 0 {
+		config.Net.ReadTimeout = options.ReadTimeout
+	}
+	if options.WriteTimeout > 0 {
+		config.Net.WriteTimeout = options.WriteTimeout
+	}
+	if err := kafkaauth.Configure(config, conf.Settings.TLS, conf.Settings.SASL); err != nil {
+		return nil, err
+	}
+
+	// the kafka client validates every remaining setting
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid kafka configuration: %w", err)
+	}
+	if config.Consumer.Group.Heartbeat.Interval >= config.Consumer.Group.Session.Timeout {
+		return nil, errors.New("Heartbeat_interval must be less than session_timeout")
+	}
+	return config, nil
+}
+
+// validateOptions makes sure each kafka setting has an accepted value,
+// and that the tls files and sasl credentials are present
+func validateOptions(conf *ezconfig.ConsumerConfig) error {
+	options := conf.Settings.Kafka
+	if _, ok := initialOffsets[options.InitialOffset]; options.InitialOffset != "" && !ok {
+		return fmt.Errorf("Invalid initial_offset %q, expected newest or oldest", options.InitialOffset)
+	}
+	if _, ok := rebalanceStrategies[options.Rebalance]; options.Rebalance != "" && !ok {
+		return fmt.Errorf("Invalid rebalance %q, expected range, roundrobin or sticky", options.Rebalance)
+	}
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"Commit_interval", options.CommitInterval},
+		{"Session_timeout", options.SessionTimeout},
+		{"Heartbeat_interval", options.HeartbeatInterval},
+		{"Max_processing_time", options.MaxProcessingTime},
+		{"Dial_timeout", options.DialTimeout},
+		{"Read_timeout", options.ReadTimeout},
+		{"Write_timeout", options.WriteTimeout},
+	}
+	for _, duration := range durations {
+		if duration.value < 0 {
+			return fmt.Errorf("%s must not be negative", duration.name)
+		}
+	}
+	return kafkaauth.Validate(conf.Settings.TLS, conf.Settings.SASL, conf.Log())
+}
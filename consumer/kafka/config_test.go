@@ -0,0 +1,83 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/explodes/ezconfig"
+)
+
+// kafkaConfig creates a valid configuration with the given kafka settings
+func kafkaConfig(options ezconfig.KafkaConsumerOptions) *ezconfig.ConsumerConfig {
+	return &ezconfig.ConsumerConfig{
+		Settings: ezconfig.ConsumerSettings{Type: kafkaConsumerType, Group: "orders", Kafka: options},
+		Hosts:    []ezconfig.BrokerHost{{Host: "localhost", Port: 9092}},
+	}
+}
+
+func TestNewConfig(t *testing.T) {
+	config, err := newConfig(kafkaConfig(ezconfig.KafkaConsumerOptions{
+		ClientID:          "orders",
+		Version:           "2.8.0",
+		InitialOffset:     "oldest",
+		CommitInterval:    5 * time.Second,
+		Rebalance:         "sticky",
+		SessionTimeout:    30 * time.Second,
+		HeartbeatInterval: 5 * time.Second,
+		MaxProcessingTime: time.Second,
+		DialTimeout:       time.Second,
+	}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	switch {
+	case config.ClientID != "orders" || config.Version != sarama.V2_8_0_0:
+		t.Fatalf("Unexpected client id %q or version %v", config.ClientID, config.Version)
+	case config.Consumer.Offsets.Initial != sarama.OffsetOldest:
+		t.Fatalf("Unexpected initial offset %d", config.Consumer.Offsets.Initial)
+	case config.Consumer.Offsets.AutoCommit.Interval != 5*time.Second:
+		t.Fatalf("Unexpected commit interval %v", config.Consumer.Offsets.AutoCommit.Interval)
+	case config.Consumer.Group.Rebalance.GroupStrategies[0] != sarama.BalanceStrategySticky:
+		t.Fatal("Expected sticky rebalancing")
+	case config.Consumer.Group.Session.Timeout != 30*time.Second || config.Consumer.Group.Heartbeat.Interval != 5*time.Second:
+		t.Fatal("Unexpected session timeout or heartbeat interval")
+	case config.Consumer.MaxProcessingTime != time.Second || config.Net.DialTimeout != time.Second:
+		t.Fatal("Unexpected max processing time or dial timeout")
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	if err := validateConfig(kafkaConfig(ezconfig.KafkaConsumerOptions{})); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	noGroup := kafkaConfig(ezconfig.KafkaConsumerOptions{})
+	noGroup.Settings.Group = ""
+	noHosts := kafkaConfig(ezconfig.KafkaConsumerOptions{})
+	noHosts.Hosts = nil
+	for name, conf := range map[string]*ezconfig.ConsumerConfig{"group": noGroup, "hosts": noHosts} {
+		if err := validateConfig(conf); err == nil {
+			t.Errorf("Expected an error without %s", name)
+		}
+	}
+
+	invalid := map[string]ezconfig.KafkaConsumerOptions{
+		"version":            {Version: "latest"},
+		"initial offset":     {InitialOffset: "middle"},
+		"rebalance":          {Rebalance: "greedy"},
+		"commit interval":    {CommitInterval: -time.Second},
+		"heartbeat interval": {SessionTimeout: 10 * time.Second, HeartbeatInterval: 10 * time.Second},
+	}
+	for name, options := range invalid {
+		if err := validateConfig(kafkaConfig(options)); err == nil {
+			t.Errorf("Expected an error for an invalid %s", name)
+		}
+	}
+
+	conf := kafkaConfig(ezconfig.KafkaConsumerOptions{})
+	conf.Settings.SASL = ezconfig.SASLConfig{Mechanism: "SCRAM-SHA-256", Username: "orders"}
+	if err := validateConfig(conf); err == nil {
+		t.Error("Expected an error for sasl without a password")
+	}
+}
@@ -0,0 +1,214 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/explodes/ezconfig"
+	"github.com/explodes/ezconfig/consumer"
+	"github.com/explodes/ezconfig/consumer/registry"
+)
+
+const (
+	// kafkaConsumerType is the value to use in configuration to connect to this consumer type
+	kafkaConsumerType = "kafka"
+)
+
+// errClosed is returned when subscribing with a closed consumer
+var errClosed = errors.New("Consumer is closed")
+
+// init registers the init and validation functions with the registry
+func init() {
+	registry.Register(kafkaConsumerType, initConsumer, validateConfig)
+}
+
+// validateConfig makes sure all the required settings are present for the consumer
+func validateConfig(conf *ezconfig.ConsumerConfig) error {
+	if len(conf.Hosts) == 0 {
+		return errors.New("Invalid consumer configuration: No [[consumers]] entry in configuration")
+	}
+	if conf.Settings.Group == "" {
+		return errors.New("Group not specified")
+	}
+	_, err := newConfig(conf)
+	return err
+}
+
+// initConsumer joins the consumer group with the given configuration
+func initConsumer(conf *ezconfig.ConsumerConfig) (consumer.Consumer, error) {
+	config, err := newConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	brokers := []string{}
+	for _, host := range conf.Hosts {
+		brokers = append(brokers, host.Address())
+	}
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	group, err := sarama.NewConsumerGroupFromClient(conf.Settings.Group, client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return newKafkaConsumer(group, client), nil
+}
+
+// newKafkaConsumer wraps a consumer group
+func newKafkaConsumer(group sarama.ConsumerGroup, client sarama.Client) *kafkaConsumer {
+	return &kafkaConsumer{group: group, client: client, closing: make(chan struct{})}
+}
+
+// kafkaConsumer receives messages from kafka as a member of a consumer group
+type kafkaConsumer struct {
+	group  sarama.ConsumerGroup
+	client sarama.Client
+
+	// mu guards starting subscriptions, which must not happen once closed
+	mu     sync.Mutex
+	closed bool
+
+	// closing is closed to stop the subscriptions, which Close waits for
+	closing       chan struct{}
+	subscriptions sync.WaitGroup
+}
+
+// Subscribe consumes the topics as a member of the group. Partitions are shared
+// with the other members, and reassigned whenever a member joins or leaves.
+// The offsets of handled messages are committed every commit_interval, and when
+// partitions are reassigned or the consumer is closed.
+func (k *kafkaConsumer) Subscribe(ctx context.Context, topics []string, handler consumer.Handler) error {
+	k.mu.Lock()
+	if k.closed {
+		k.mu.Unlock()
+		return errClosed
+	}
+	k.subscriptions.Add(1)
+	k.mu.Unlock()
+	defer k.subscriptions.Done()
+
+	parent := ctx
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	go func() {
+		select {
+		case <-k.closing:
+			stop()
+		case <-ctx.Done():
+		}
+	}()
+
+	h := &groupHandler{handler: handler, stop: stop}
+	for {
+		err := k.group.Consume(ctx, topics, h)
+		switch {
+		case h.failure() != nil:
+			return h.failure()
+		case parent.Err() != nil:
+			return nil
+		case ctx.Err() != nil, errors.Is(err, sarama.ErrClosedConsumerGroup):
+			return errClosed
+		case err != nil:
+			return err
+		}
+		// partitions were reassigned, join the group again
+	}
+}
+
+// Check refreshes the cluster metadata to make sure the brokers can be reached
+func (k *kafkaConsumer) Check(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- k.client.RefreshMetadata()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops the subscriptions, waiting for them to commit the offsets of
+// handled messages, then leaves the group and closes the connection to kafka
+func (k *kafkaConsumer) Close() error {
+	k.mu.Lock()
+	if k.closed {
+		k.mu.Unlock()
+		return nil
+	}
+	k.closed = true
+	close(k.closing)
+	k.mu.Unlock()
+
+	k.subscriptions.Wait()
+	err := k.group.Close()
+	if k.client == nil {
+		return err
+	}
+	return errors.Join(err, k.client.Close())
+}
+
+// groupHandler is the sarama.ConsumerGroupHandler that calls a consumer.Handler
+type groupHandler struct {
+	handler consumer.Handler
+
+	// stop ends the subscription once the handler fails
+	stop context.CancelFunc
+
+	mu  sync.Mutex
+	err error
+}
+
+// Setup is called when partitions are assigned
+func (h *groupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// Cleanup commits the offsets of handled messages before partitions are released
+func (h *groupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	session.Commit()
+	return nil
+}
+
+// ConsumeClaim handles the messages of a partition in order, marking each one
+// handled so that its offset is committed. It stops at the first failure, so
+// that the failed message and those after it are not committed.
+func (h *groupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for {
+		select {
+		case message, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			if err := h.handler(session.Context(), consumerMessage(message)); err != nil {
+				h.fail(err)
+				return err
+			}
+			session.MarkMessage(message, "")
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
+
+// fail records the first error returned by the handler and ends the subscription
+func (h *groupHandler) fail(err error) {
+	h.mu.Lock()
+	if h.err == nil {
+		h.err = err
+	}
+	h.mu.Unlock()
+	h.stop()
+}
+
+// failure is the first error returned by the handler
+func (h *groupHandler) failure() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.err
+}
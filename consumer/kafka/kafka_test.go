@@ -0,0 +1,196 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/explodes/ezconfig/consumer"
+	"github.com/explodes/ezconfig/consumer/registry"
+)
+
+func TestDetermineFactory(t *testing.T) {
+	factory, ok := registry.Get(kafkaConsumerType)
+	if !ok {
+		t.Fatal("Kafka factory not registered")
+	}
+	sf1 := reflect.ValueOf(initConsumer)
+	sf2 := reflect.ValueOf(factory.Init)
+	if sf1.Pointer() != sf2.Pointer() {
+		t.Fatal("Unexpected init function")
+	}
+	sf1 = reflect.ValueOf(validateConfig)
+	sf2 = reflect.ValueOf(factory.Validate)
+	if sf1.Pointer() != sf2.Pointer() {
+		t.Fatal("Unexpected validate function")
+	}
+}
+
+// fakeSession is a group session that records the messages marked as handled
+type fakeSession struct {
+	sarama.ConsumerGroupSession
+	ctx context.Context
+
+	mu        sync.Mutex
+	marked    []int64
+	committed bool
+}
+
+func (s *fakeSession) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.marked = append(s.marked, msg.Offset)
+}
+
+func (s *fakeSession) Commit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.committed = true
+}
+
+// fakeClaim is a claimed partition holding the given messages
+type fakeClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+// newClaim creates a claim of messages with offsets 0 to count-1 of the orders topic
+func newClaim(count int) *fakeClaim {
+	claim := &fakeClaim{messages: make(chan *sarama.ConsumerMessage, count)}
+	for i := 0; i < count; i++ {
+		claim.messages <- &sarama.ConsumerMessage{Topic: "orders", Offset: int64(i)}
+	}
+	close(claim.messages)
+	return claim
+}
+
+// fakeGroup is a consumer group that runs one session over a claim on each Consume
+type fakeGroup struct {
+	sarama.ConsumerGroup
+	claims  chan *fakeClaim
+	session *fakeSession
+	closed  bool
+}
+
+func (g *fakeGroup) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	if g.closed {
+		return sarama.ErrClosedConsumerGroup
+	}
+	select {
+	case claim := <-g.claims:
+		g.session = &fakeSession{ctx: ctx}
+		handler.Setup(g.session)
+		handler.ConsumeClaim(g.session, claim)
+		return handler.Cleanup(g.session)
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+func (g *fakeGroup) Close() error {
+	g.closed = true
+	return nil
+}
+
+func TestKafkaConsumer_Subscribe(t *testing.T) {
+	group := &fakeGroup{claims: make(chan *fakeClaim, 1)}
+	group.claims <- newClaim(3)
+	k := newKafkaConsumer(group, nil)
+
+	errHandler := errors.New("handler failed")
+	var handled []int64
+	err := k.Subscribe(context.Background(), []string{"orders"}, func(ctx context.Context, msg *consumer.Message) error {
+		handled = append(handled, msg.Offset)
+		if msg.Offset == 1 {
+			return errHandler
+		}
+		return nil
+	})
+	if err != errHandler {
+		t.Fatalf("Expected the handler error, got %v", err)
+	}
+	// the failed message is not marked, so it is received again
+	if !reflect.DeepEqual(handled, []int64{0, 1}) || !reflect.DeepEqual(group.session.marked, []int64{0}) {
+		t.Fatalf("Unexpected handled %v and marked %v offsets", handled, group.session.marked)
+	}
+	if !group.session.committed {
+		t.Fatal("Expected offsets to be committed when the session ended")
+	}
+}
+
+func TestKafkaConsumer_Subscribe_done(t *testing.T) {
+	group := &fakeGroup{claims: make(chan *fakeClaim, 1)}
+	group.claims <- newClaim(2)
+	k := newKafkaConsumer(group, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := k.Subscribe(ctx, []string{"orders"}, func(ctx context.Context, msg *consumer.Message) error {
+		if msg.Offset == 1 {
+			cancel()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(group.session.marked, []int64{0, 1}) {
+		t.Fatalf("Unexpected marked offsets %v", group.session.marked)
+	}
+}
+
+func TestKafkaConsumer_Close(t *testing.T) {
+	group := &fakeGroup{claims: make(chan *fakeClaim)}
+	k := newKafkaConsumer(group, nil)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- k.Subscribe(context.Background(), []string{"orders"}, func(ctx context.Context, msg *consumer.Message) error {
+			return nil
+		})
+	}()
+	if err := k.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := <-done; err != errClosed {
+		t.Fatalf("Expected a closed error, got %v", err)
+	}
+	if !group.closed {
+		t.Fatal("Expected the group to be closed")
+	}
+	if err := k.Subscribe(context.Background(), []string{"orders"}, nil); err != errClosed {
+		t.Fatalf("Expected a closed error, got %v", err)
+	}
+}
+
+func TestConsumerMessage(t *testing.T) {
+	msg := consumerMessage(&sarama.ConsumerMessage{
+		Topic:     "orders",
+		Key:       []byte("order-42"),
+		Value:     []byte("shipped"),
+		Partition: 2,
+		Offset:    7,
+		Headers:   []*sarama.RecordHeader{{Key: []byte("trace"), Value: []byte("abc")}},
+	})
+	expected := &consumer.Message{
+		Topic:     "orders",
+		Key:       []byte("order-42"),
+		Value:     []byte("shipped"),
+		Headers:   map[string][]byte{"trace": []byte("abc")},
+		Partition: 2,
+		Offset:    7,
+	}
+	if !reflect.DeepEqual(msg, expected) {
+		t.Fatalf("Unexpected message %+v", msg)
+	}
+}
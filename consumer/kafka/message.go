@@ -0,0 +1,25 @@
+package kafka
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/explodes/ezconfig/consumer"
+)
+
+// consumerMessage maps a sarama message to a message
+func consumerMessage(message *sarama.ConsumerMessage) *consumer.Message {
+	msg := &consumer.Message{
+		Topic:     message.Topic,
+		Key:       message.Key,
+		Value:     message.Value,
+		Timestamp: message.Timestamp,
+		Partition: message.Partition,
+		Offset:    message.Offset,
+	}
+	if len(message.Headers) > 0 {
+		msg.Headers = make(map[string][]byte, len(message.Headers))
+		for _, header := range message.Headers {
+			msg.Headers[string(header.Key)] = header.Value
+		}
+	}
+	return msg
+}
@@ -0,0 +1,46 @@
+package registry
+
+import (
+	"github.com/explodes/ezconfig"
+	"github.com/explodes/ezconfig/consumer"
+)
+
+// InitFunc is a function that takes consumer configuration and turns
+// it into a Consumer
+type InitFunc func(conf *ezconfig.ConsumerConfig) (consumer.Consumer, error)
+
+// ValidateFunc is a function that checks configuration to see if it
+// works for a given consumer type
+type ValidateFunc func(conf *ezconfig.ConsumerConfig) error
+
+// ConsumerFactory holds the requirements to validate and connect to a consumer
+type ConsumerFactory struct {
+	Init     InitFunc
+	Validate ValidateFunc
+}
+
+// registry holds the registered consumer types
+var registry = make(map[string]*ConsumerFactory)
+
+// Register registers init and validation functions for a given consumer type
+func Register(consumerType string, init InitFunc, validate ValidateFunc) {
+	if init == nil {
+		panic("ezconfig: init function is nil")
+	}
+	if validate == nil {
+		panic("ezconfig: validate function is nil")
+	}
+	if _, dup := registry[consumerType]; dup {
+		panic("ezconfig: Register called twice for type " + consumerType)
+	}
+	registry[consumerType] = &ConsumerFactory{
+		Init:     init,
+		Validate: validate,
+	}
+}
+
+// Get acquires the registered consumer type and returns its related init and validation functions
+func Get(consumerType string) (*ConsumerFactory, bool) {
+	factory, ok := registry[consumerType]
+	return factory, ok
+}
@@ -1,13 +1,19 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/explodes/ezconfig"
 	"github.com/explodes/ezconfig/db/registry"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 const (
@@ -18,11 +24,106 @@ const (
 // init registers the init and validation functions with the registry
 func init() {
 	registry.Register(pgDbType, initDb, validateDb)
+	registry.AllowParams(pgDbType, pgParams...)
+}
+
+// pgParams are the [database.params] keys passed through to postgres.
+// Keys that are not connection settings are sent as run-time parameters.
+var pgParams = []string{
+	"application_name",
+	"fallback_application_name",
+	"connect_timeout",
+	"statement_timeout",
+	"lock_timeout",
+	"idle_in_transaction_session_timeout",
+	"search_path",
+	"timezone",
+}
+
+// connectionURL builds an escaped connection URL from the supplied configuration
+func connectionURL(conf *ezconfig.DbConfig) *url.URL {
+	query := url.Values{}
+	for param, value := range conf.Database.Params {
+		query.Set(param, value)
+	}
+	query.Set("sslmode", sslMode(conf))
+	setTLSParams(conf, query)
+	return &url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(conf.Database.User, conf.Database.Password),
+		Host:     net.JoinHostPort(serverName(conf), strconv.Itoa(conf.Database.Port)),
+		Path:     "/" + conf.Database.DbName,
+		RawQuery: query.Encode(),
+	}
 }
 
 // getConnectionString builds a connection string from the supplied configuration
 func getConnectionString(conf *ezconfig.DbConfig) string {
-	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=%s", conf.Database.User, conf.Database.Password, conf.Database.Host, conf.Database.Port, conf.Database.DbName, conf.Database.Ssl)
+	return connectionURL(conf).String()
+}
+
+// redactedConnectionString builds a connection string with the password masked,
+// suitable for logs and error messages
+func redactedConnectionString(conf *ezconfig.DbConfig) string {
+	return connectionURL(conf).Redacted()
+}
+
+// redactedError is an error whose message has had credentials removed.
+// It deliberately does not unwrap to the original error.
+type redactedError struct {
+	msg string
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+// redactError replaces the connection string and password, in any of its
+// escaped forms, in the message of err. Errors without credentials are returned
+// as they are, so that database/sql can still recognize driver.ErrBadConn.
+func redactError(conf *ezconfig.DbConfig, err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := strings.ReplaceAll(err.Error(), getConnectionString(conf), redactedConnectionString(conf))
+	if password := conf.Database.Password; password != "" {
+		userinfo := strings.TrimPrefix(url.UserPassword("", password).String(), ":")
+		for _, form := range []string{password, userinfo, url.QueryEscape(password), url.PathEscape(password)} {
+			msg = strings.ReplaceAll(msg, form, "xxxxx")
+		}
+	}
+	if msg == err.Error() {
+		return err
+	}
+	return &redactedError{msg: msg}
+}
+
+// redactingConnector redacts the errors of the connections it opens,
+// including those returned when the database is pinged
+type redactingConnector struct {
+	conf      *ezconfig.DbConfig
+	connector driver.Connector
+}
+
+func (c *redactingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.connector.Connect(ctx)
+	if err != nil {
+		return nil, redactError(c.conf, err)
+	}
+	return conn, nil
+}
+
+func (c *redactingConnector) Driver() driver.Driver {
+	return c.connector.Driver()
+}
+
+// sslMode is the sslmode connection parameter. With a ca_file, require verifies
+// the server certificate chain as verify-ca does, as it would with libpq.
+func sslMode(conf *ezconfig.DbConfig) string {
+	if conf.Database.Ssl == "require" && conf.Database.TLS.CAFile != "" {
+		return "verify-ca"
+	}
+	return conf.Database.Ssl
 }
 
 // validateDb makes sure all the required settings are present for the database
@@ -45,11 +146,26 @@ func validateDb(conf *ezconfig.DbConfig) error {
 	if conf.Database.Password == "" {
 		return errors.New("Password not specified")
 	}
+	if conf.Database.TLS.Enabled() {
+		return validateTLS(conf)
+	}
 	return nil
 }
 
-// initDb establishes a connection with the given configuration
-func initDb(conf *ezconfig.DbConfig) (*sql.DB, error) {
-	connStr := getConnectionString(conf)
-	return sql.Open("postgres", connStr)
+// initDb establishes a connection with the given configuration.
+// Its errors, and those of the connections it opens, have their credentials redacted.
+func initDb(conf *ezconfig.DbConfig) (db *sql.DB, err error) {
+	defer func() {
+		err = redactError(conf, err)
+	}()
+	// parse the connection string now, rather than on first use,
+	// so that any error is returned here
+	connector, err := pq.NewConnector(getConnectionString(conf))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open database: %v", err)
+	}
+	if serverName(conf) != conf.Database.Host {
+		connector.Dialer(newHostDialer(conf))
+	}
+	return sql.OpenDB(&redactingConnector{conf: conf, connector: connector}), nil
 }
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/explodes/ezconfig"
+	"github.com/explodes/ezconfig/internal/tlsconf"
+)
+
+// tlsModes are the sslmode values that may be combined with [database.tls]
+var tlsModes = map[string]bool{
+	"require":     true,
+	"verify-ca":   true,
+	"verify-full": true,
+}
+
+// validateTLS makes sure the [database.tls] files exist and can be loaded
+func validateTLS(conf *ezconfig.DbConfig) error {
+	if !tlsModes[conf.Database.Ssl] {
+		return fmt.Errorf("Ssl must be require, verify-ca or verify-full when tls is configured, got %q", conf.Database.Ssl)
+	}
+	settings := tlsSettings(conf)
+	if err := tlsconf.Validate(settings); err != nil {
+		return err
+	}
+	_, err := tlsconf.Load(settings)
+	return err
+}
+
+// tlsSettings converts the [database.tls] certificates so that they can be validated
+func tlsSettings(conf *ezconfig.DbConfig) ezconfig.TLSConfig {
+	settings := conf.Database.TLS
+	return ezconfig.TLSConfig{
+		CAFile:   settings.CAFile,
+		CertFile: settings.CertFile,
+		KeyFile:  settings.KeyFile,
+	}
+}
+
+// setTLSParams adds the [database.tls] certificates to the connection parameters
+func setTLSParams(conf *ezconfig.DbConfig, query url.Values) {
+	settings := conf.Database.TLS
+	if settings.CAFile != "" {
+		query.Set("sslrootcert", settings.CAFile)
+	}
+	if settings.CertFile != "" {
+		query.Set("sslcert", settings.CertFile)
+		query.Set("sslkey", settings.KeyFile)
+	}
+}
+
+// serverName is the host lib/pq verifies the server certificate against.
+// lib/pq has no parameter for it, so when it differs from the host it is given
+// to lib/pq as the host, and connections are dialed to the host by hostDialer.
+func serverName(conf *ezconfig.DbConfig) string {
+	if conf.Database.TLS.ServerName != "" {
+		return conf.Database.TLS.ServerName
+	}
+	return conf.Database.Host
+}
+
+// hostDialer dials the configured host, whatever address lib/pq asks for
+type hostDialer struct {
+	address string
+	dialer  net.Dialer
+}
+
+// newHostDialer creates a hostDialer for the configured host and port
+func newHostDialer(conf *ezconfig.DbConfig) *hostDialer {
+	return &hostDialer{address: net.JoinHostPort(conf.Database.Host, strconv.Itoa(conf.Database.Port))}
+}
+
+func (d *hostDialer) Dial(network, _ string) (net.Conn, error) {
+	return d.dialer.Dial(network, d.address)
+}
+
+func (d *hostDialer) DialTimeout(network, _ string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout(network, d.address, timeout)
+}
+
+func (d *hostDialer) DialContext(ctx context.Context, network, _ string) (net.Conn, error) {
+	return d.dialer.DialContext(ctx, network, d.address)
+}
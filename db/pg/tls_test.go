@@ -0,0 +1,95 @@
+package db
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/explodes/ezconfig"
+)
+
+func tlsTestConfig(caFile, certFile, keyFile string) *ezconfig.DbConfig {
+	return &ezconfig.DbConfig{
+		Database: ezconfig.DbHost{
+			Type:     pgDbType,
+			Host:     "localhost",
+			Port:     5432,
+			User:     "test",
+			Password: "test",
+			DbName:   "test",
+			Ssl:      "verify-full",
+			TLS: ezconfig.DbTLS{
+				CAFile:     caFile,
+				CertFile:   certFile,
+				KeyFile:    keyFile,
+				ServerName: "db.internal",
+			},
+		},
+	}
+}
+
+func TestValidateDb_tls(t *testing.T) {
+	conf := tlsTestConfig("", "", "")
+	if err := validateDb(conf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestGetConnectionString_tls(t *testing.T) {
+	conf := tlsTestConfig("/etc/ssl/db/ca.pem", "/etc/ssl/db/client.pem", "/etc/ssl/db/client.key")
+	parsed, err := url.Parse(getConnectionString(conf))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	query := parsed.Query()
+	if query.Get("sslmode") != "verify-full" || query.Get("sslrootcert") != "/etc/ssl/db/ca.pem" ||
+		query.Get("sslcert") != "/etc/ssl/db/client.pem" || query.Get("sslkey") != "/etc/ssl/db/client.key" {
+		t.Fatalf("Unexpected tls parameters %v", query)
+	}
+	// lib/pq verifies the certificate against the host it is given
+	if parsed.Host != "db.internal:5432" {
+		t.Fatalf("Expected the server name as host, got %s", parsed.Host)
+	}
+
+	// a ca file makes require verify the certificate chain
+	conf.Database.Ssl = "require"
+	if parsed, _ = url.Parse(getConnectionString(conf)); parsed.Query().Get("sslmode") != "verify-ca" {
+		t.Fatalf("Expected verify-ca, got %s", parsed.Query().Get("sslmode"))
+	}
+	conf.Database.TLS.CAFile = ""
+	if parsed, _ = url.Parse(getConnectionString(conf)); parsed.Query().Get("sslmode") != "require" {
+		t.Fatalf("Expected require, got %s", parsed.Query().Get("sslmode"))
+	}
+}
+
+func TestHostDialer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	conf := tlsTestConfig("", "", "")
+	conf.Database.Host = "127.0.0.1"
+	conf.Database.Port = listener.Addr().(*net.TCPAddr).Port
+
+	// connections go to the host rather than the server name lib/pq was given
+	conn, err := newHostDialer(conf).DialContext(context.Background(), "tcp", "db.internal:5432")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	conn.Close()
+}
+
+func TestValidateDb_tlsErrors(t *testing.T) {
+	missing := tlsTestConfig("/missing/ca.pem", "", "")
+	if err := validateDb(missing); err == nil {
+		t.Fatal("Expected an error for a missing ca file")
+	}
+
+	disabled := tlsTestConfig("", "", "")
+	disabled.Database.Ssl = "disable"
+	if err := validateDb(disabled); err == nil {
+		t.Fatal("Expected an error for tls with ssl disabled")
+	}
+}
@@ -2,6 +2,8 @@ package registry
 
 import (
 	"database/sql"
+	"fmt"
+	"sort"
 
 	"github.com/explodes/ezconfig"
 )
@@ -18,6 +20,9 @@ type ValidateFunc func(conf *ezconfig.DbConfig) error
 type DbFactory struct {
 	Init     InitFunc
 	Validate ValidateFunc
+
+	// Params is the set of [database.params] keys the database type accepts
+	Params map[string]bool
 }
 
 // registry holds the registered database types
@@ -37,6 +42,19 @@ func Register(dbType string, init InitFunc, validate ValidateFunc) {
 	registry[dbType] = &DbFactory{
 		Init:     init,
 		Validate: validate,
+		Params:   make(map[string]bool),
+	}
+}
+
+// AllowParams declares which [database.params] keys are passed through to the driver
+// for a given database type. The database type must already be registered.
+func AllowParams(dbType string, params ...string) {
+	factory, ok := registry[dbType]
+	if !ok {
+		panic("ezconfig: AllowParams called for unregistered type " + dbType)
+	}
+	for _, param := range params {
+		factory.Params[param] = true
 	}
 }
 
@@ -45,3 +63,18 @@ func Get(dbType string) (*DbFactory, bool) {
 	factory, ok := registry[dbType]
 	return factory, ok
 }
+
+// ValidateParams makes sure every configured driver parameter is allowed by the database type
+func (f *DbFactory) ValidateParams(conf *ezconfig.DbConfig) error {
+	var unknown []string
+	for param := range conf.Database.Params {
+		if !f.Params[param] {
+			unknown = append(unknown, param)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return fmt.Errorf("Unsupported parameters for database type %s: %v", conf.Database.Type, unknown)
+}
@@ -1,12 +1,20 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/explodes/ezconfig"
 	"github.com/explodes/ezconfig/db/registry"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 const (
@@ -17,6 +25,70 @@ const (
 // init registers the init and validation functions with the registry
 func init() {
 	registry.Register(sqliteDbType, initDb, validateConfig)
+	registry.AllowParams(sqliteDbType, sqliteParams...)
+}
+
+// sqliteParams are the [database.params] keys passed through to go-sqlite3
+var sqliteParams = []string{
+	"cache",
+	"mode",
+	"_loc",
+	"_mutex",
+	"_txlock",
+}
+
+// journalModes are the accepted values for journal_mode
+var journalModes = map[string]bool{
+	"DELETE":   true,
+	"TRUNCATE": true,
+	"PERSIST":  true,
+	"MEMORY":   true,
+	"WAL":      true,
+	"OFF":      true,
+}
+
+var (
+	// pragmaName matches pragma names, optionally qualified by a schema
+	pragmaName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+	// pragmaValue matches unquoted pragma values such as NORMAL, 1 or -2000
+	pragmaValue = regexp.MustCompile(`^-?[A-Za-z0-9_]+$`)
+)
+
+// getConnectionString builds a connection string from the supplied configuration.
+// When parameters are present the host is turned into a "file:" URI so that
+// sqlite's own URI parameters (cache, mode) are honored. Parameters already in the
+// host are kept unless they are configured as well.
+func getConnectionString(conf *ezconfig.DbConfig) string {
+	query := url.Values{}
+	for param, value := range conf.Database.Params {
+		query.Set(param, value)
+	}
+	options := conf.Database.Sqlite
+	if options.JournalMode != "" {
+		query.Set("_journal_mode", strings.ToUpper(options.JournalMode))
+	}
+	if options.BusyTimeout > 0 {
+		query.Set("_busy_timeout", strconv.Itoa(options.BusyTimeout))
+	}
+	if options.ForeignKeys {
+		query.Set("_foreign_keys", "1")
+	}
+	if options.Cache != "" {
+		query.Set("cache", options.Cache)
+	}
+	if len(query) == 0 {
+		return conf.Database.Host
+	}
+	dsn, existing, _ := strings.Cut(conf.Database.Host, "?")
+	if !strings.HasPrefix(dsn, "file:") {
+		dsn = "file:" + dsn
+	}
+	// the host query is checked by validateConfig
+	merged, _ := url.ParseQuery(existing)
+	for param, values := range query {
+		merged[param] = values
+	}
+	return dsn + "?" + merged.Encode()
 }
 
 // validateConfig makes sure all the required settings are present for the database
@@ -24,10 +96,97 @@ func validateConfig(conf *ezconfig.DbConfig) error {
 	if conf.Database.Host == "" {
 		return errors.New("Host not specified")
 	}
+	if _, query, ok := strings.Cut(conf.Database.Host, "?"); ok {
+		if _, err := url.ParseQuery(query); err != nil {
+			return fmt.Errorf("Invalid parameters in host: %v", err)
+		}
+	}
+	options := conf.Database.Sqlite
+	if options.JournalMode != "" && !journalModes[strings.ToUpper(options.JournalMode)] {
+		return fmt.Errorf("Invalid journal_mode %q", options.JournalMode)
+	}
+	if options.BusyTimeout < 0 {
+		return errors.New("Busy_timeout must not be negative")
+	}
+	if options.Cache != "" && options.Cache != "shared" && options.Cache != "private" {
+		return fmt.Errorf("Invalid cache %q, expected shared or private", options.Cache)
+	}
+	for name, value := range options.Pragmas {
+		if !pragmaName.MatchString(name) {
+			return fmt.Errorf("Invalid pragma name %q", name)
+		}
+		if !pragmaValue.MatchString(value) {
+			return fmt.Errorf("Invalid value %q for pragma %s", value, name)
+		}
+	}
+	if isMemory(conf) && conf.Database.MaxConnections != 1 && !isSharedCache(conf) {
+		conf.Log().Warn("sqlite3 in-memory database without a shared cache gives each connection its own empty database",
+			"host", conf.Database.Host,
+			"max_connections", conf.Database.MaxConnections)
+	}
 	return nil
 }
 
+// isMemory reports whether the configuration describes an in-memory database
+func isMemory(conf *ezconfig.DbConfig) bool {
+	host := strings.TrimPrefix(conf.Database.Host, "file:")
+	return strings.HasPrefix(host, ":memory:") || conf.Database.Params["mode"] == "memory"
+}
+
+// isSharedCache reports whether connections share a single cache
+func isSharedCache(conf *ezconfig.DbConfig) bool {
+	if conf.Database.Sqlite.Cache != "" {
+		return conf.Database.Sqlite.Cache == "shared"
+	}
+	return conf.Database.Params["cache"] == "shared"
+}
+
+// pragmaStatements builds the PRAGMA statements to execute on each new connection
+func pragmaStatements(conf *ezconfig.DbConfig) []string {
+	statements := make([]string, 0, len(conf.Database.Sqlite.Pragmas))
+	for name, value := range conf.Database.Sqlite.Pragmas {
+		statements = append(statements, fmt.Sprintf("PRAGMA %s = %s", name, value))
+	}
+	sort.Strings(statements)
+	return statements
+}
+
 // initDb establishes a connection with the given configuration
 func initDb(conf *ezconfig.DbConfig) (*sql.DB, error) {
-	return sql.Open("sqlite3", conf.Database.Host)
+	pragmas := pragmaStatements(conf)
+	if len(pragmas) == 0 {
+		return sql.Open("sqlite3", getConnectionString(conf))
+	}
+	return sql.OpenDB(&connector{
+		dsn:     getConnectionString(conf),
+		pragmas: pragmas,
+		driver:  &sqlite3.SQLiteDriver{},
+	}), nil
+}
+
+// connector opens sqlite3 connections and executes the configured pragmas on each of them
+type connector struct {
+	dsn     string
+	pragmas []string
+	driver  *sqlite3.SQLiteDriver
+}
+
+// Connect opens a new connection and applies the pragmas
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	for _, pragma := range c.pragmas {
+		if _, err := conn.(*sqlite3.SQLiteConn).Exec(pragma, nil); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s: %v", pragma, err)
+		}
+	}
+	return conn, nil
+}
+
+// Driver returns the underlying sqlite3 driver
+func (c *connector) Driver() driver.Driver {
+	return c.driver
 }
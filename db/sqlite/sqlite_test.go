@@ -1,8 +1,13 @@
 package db
 
 import (
+	"bytes"
+	"log"
+	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/explodes/ezconfig"
 	"github.com/explodes/ezconfig/backoff"
@@ -44,3 +49,171 @@ func TestInitDatbase_sqlitedummy(t *testing.T) {
 	}
 	db.Close()
 }
+
+func TestInitDatabase_sharedMemory(t *testing.T) {
+	conf := &ezconfig.DbConfig{
+		Database: ezconfig.DbHost{
+			Type:   "sqlite3",
+			Host:   ":memory:",
+			Params: map[string]string{"cache": "shared"},
+		},
+	}
+	if connStr := getConnectionString(conf); connStr != "file::memory:?cache=shared" {
+		t.Fatalf("Unexpected connection string %q", connStr)
+	}
+
+	db, err := opener.InitDb(conf, 0, backoff.Constant(1))
+	if err != nil {
+		t.Fatalf("Error creating shared database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(2)
+
+	// hold one connection open so the second one must share the cache
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := db.Exec(`CREATE TABLE shared (id INTEGER)`); err != nil {
+		t.Fatalf("Error creating table: %v", err)
+	}
+	if _, err := tx.Exec(`SELECT id FROM shared`); err != nil {
+		t.Fatalf("Table not visible from second connection: %v", err)
+	}
+}
+
+func TestGetConnectionString_options(t *testing.T) {
+	conf := &ezconfig.DbConfig{
+		Database: ezconfig.DbHost{
+			Type: "sqlite3",
+			Host: "app.db",
+			Sqlite: ezconfig.SqliteOptions{
+				JournalMode: "wal",
+				BusyTimeout: 5000,
+				ForeignKeys: true,
+			},
+		},
+	}
+	expected := "file:app.db?_busy_timeout=5000&_foreign_keys=1&_journal_mode=WAL"
+	if connStr := getConnectionString(conf); connStr != expected {
+		t.Fatalf("Unexpected connection string %q", connStr)
+	}
+}
+
+func TestGetConnectionString_hostParams(t *testing.T) {
+	conf := &ezconfig.DbConfig{
+		Database: ezconfig.DbHost{
+			Type:   "sqlite3",
+			Host:   "file:app.db?mode=ro&cache=private",
+			Params: map[string]string{"cache": "shared"},
+			Sqlite: ezconfig.SqliteOptions{BusyTimeout: 5000},
+		},
+	}
+	expected := "file:app.db?_busy_timeout=5000&cache=shared&mode=ro"
+	if connStr := getConnectionString(conf); connStr != expected {
+		t.Fatalf("Unexpected connection string %q", connStr)
+	}
+
+	conf.Database.Host = "app.db?mode=%zz"
+	if err := validateConfig(conf); err == nil {
+		t.Fatal("Expected an error for invalid host parameters")
+	}
+}
+
+func TestValidateConfig_options(t *testing.T) {
+	invalid := []ezconfig.SqliteOptions{
+		{JournalMode: "sideways"},
+		{BusyTimeout: -1},
+		{Cache: "public"},
+		{Pragmas: map[string]string{"synchronous; DROP TABLE x": "OFF"}},
+		{Pragmas: map[string]string{"synchronous": "OFF; DROP TABLE x"}},
+	}
+	for _, options := range invalid {
+		conf := &ezconfig.DbConfig{
+			Database: ezconfig.DbHost{Type: "sqlite3", Host: ":memory:", MaxConnections: 1, Sqlite: options},
+		}
+		if err := validateConfig(conf); err == nil {
+			t.Errorf("Expected an error for %+v", options)
+		}
+	}
+}
+
+func TestValidateConfig_memoryWarning(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	conf := &ezconfig.DbConfig{
+		Database: ezconfig.DbHost{Type: "sqlite3", Host: ":memory:", MaxConnections: 4},
+	}
+	if err := validateConfig(conf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "shared cache") {
+		t.Fatalf("Expected a warning, got %q", buf.String())
+	}
+
+	buf.Reset()
+	conf.Database.Sqlite.Cache = "shared"
+	if err := validateConfig(conf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("Unexpected warning %q", buf.String())
+	}
+}
+
+func TestInitDatabase_pragmas(t *testing.T) {
+	conf := &ezconfig.DbConfig{
+		Database: ezconfig.DbHost{
+			Type:           "sqlite3",
+			Host:           ":memory:",
+			MaxConnections: 1,
+			Sqlite: ezconfig.SqliteOptions{
+				ForeignKeys: true,
+				Pragmas:     map[string]string{"cache_size": "-4000"},
+			},
+		},
+	}
+	db, err := opener.InitDb(conf, 0, backoff.Constant(1))
+	if err != nil {
+		t.Fatalf("Error creating database: %v", err)
+	}
+	defer db.Close()
+
+	var foreignKeys, cacheSize int
+	if err := db.QueryRow(`PRAGMA foreign_keys`).Scan(&foreignKeys); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(`PRAGMA cache_size`).Scan(&cacheSize); err != nil {
+		t.Fatal(err)
+	}
+	if foreignKeys != 1 || cacheSize != -4000 {
+		t.Fatalf("Pragmas not applied: foreign_keys=%d cache_size=%d", foreignKeys, cacheSize)
+	}
+}
+
+func TestConnect_withMigrations(t *testing.T) {
+	conf := &ezconfig.DbConfig{
+		Database: ezconfig.DbHost{
+			Type:           "sqlite3",
+			Host:           ":memory:",
+			MaxConnections: 1,
+		},
+	}
+	migrations := fstest.MapFS{
+		"0001_create_users.sql": {Data: []byte(`CREATE TABLE users (id INTEGER PRIMARY KEY);`)},
+	}
+	connections, err := opener.New().
+		WithDatabase(conf).
+		WithMigrations(migrations).
+		Connect()
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer connections.Close()
+	if _, err := connections.DB.Exec(`INSERT INTO users (id) VALUES (1)`); err != nil {
+		t.Fatalf("Database was not migrated: %v", err)
+	}
+}
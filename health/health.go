@@ -0,0 +1,205 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/explodes/ezconfig/opener"
+)
+
+const (
+	// DefaultTimeout is how long each resource is given to pass its check, see WithTimeout
+	DefaultTimeout = time.Second
+
+	// DefaultCacheTTL is how long check results are reused for, see WithCacheTTL
+	DefaultCacheTTL = 2 * time.Second
+)
+
+// Status summarizes the health of a resource or of every resource
+type Status string
+
+const (
+	// StatusOK means every check passed
+	StatusOK Status = "ok"
+	// StatusDegraded means only optional resources failed their checks
+	StatusDegraded Status = "degraded"
+	// StatusUnavailable means a required resource failed its check, or the
+	// service is shutting down
+	StatusUnavailable Status = "unavailable"
+)
+
+// Report is the result of checking every resource
+type Report struct {
+	Status    Status                    `json:"status"`
+	CheckedAt time.Time                 `json:"checked_at"`
+	Resources map[string]ResourceReport `json:"resources,omitempty"`
+}
+
+// ResourceReport is the result of checking a resource
+type ResourceReport struct {
+	Status   Status  `json:"status"`
+	State    string  `json:"state"`
+	Optional bool    `json:"optional"`
+	Latency  float64 `json:"latency_ms"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Handler checks the health of Connections.
+// Liveness only reports that the process is serving requests, readiness checks
+// every resource, each with its own timeout, and caches the result.
+//
+//	checker := health.New(connections)
+//	mux.Handle("/healthz", checker.Live())
+//	mux.Handle("/readyz", checker.Ready())
+//	manager.OnShutdown("health", checker.Shutdown)
+type Handler struct {
+	connections *opener.Connections
+	timeout     time.Duration
+	ttl         time.Duration
+
+	// mu serializes checks so that concurrent requests share results
+	mu     sync.Mutex
+	cached *Report
+
+	shuttingDown atomic.Bool
+}
+
+// New creates a Handler checking the connections with the default timeout and cache TTL
+func New(connections *opener.Connections) *Handler {
+	return &Handler{
+		connections: connections,
+		timeout:     DefaultTimeout,
+		ttl:         DefaultCacheTTL,
+	}
+}
+
+// WithTimeout sets how long each resource is given to pass its check
+func (h *Handler) WithTimeout(timeout time.Duration) *Handler {
+	h.timeout = timeout
+	return h
+}
+
+// WithCacheTTL sets how long check results are reused for, zero to check on every request
+func (h *Handler) WithCacheTTL(ttl time.Duration) *Handler {
+	h.ttl = ttl
+	return h
+}
+
+// Shutdown marks the service as not ready, so that load balancers stop sending it
+// requests while it shuts down. It has the signature of a lifecycle.ShutdownFunc.
+func (h *Handler) Shutdown(ctx context.Context) error {
+	h.shuttingDown.Store(true)
+	return nil
+}
+
+// Live reports that the process is serving requests, without checking any resource
+func (h *Handler) Live() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeReport(w, &Report{Status: StatusOK, CheckedAt: time.Now()})
+	})
+}
+
+// Ready reports the health of every resource. It responds with 503 Service Unavailable
+// when a required resource fails its check or the service is shutting down.
+func (h *Handler) Ready() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeReport(w, h.Report(req.Context()))
+	})
+}
+
+// ServeHTTP serves readiness, see Ready
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.Ready().ServeHTTP(w, req)
+}
+
+// Report checks every resource, or returns the cached result if it is recent enough
+func (h *Handler) Report(ctx context.Context) *Report {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.cached == nil || time.Since(h.cached.CheckedAt) >= h.ttl {
+		// the result is shared, so it must not depend on one caller going away
+		h.cached = h.check(context.WithoutCancel(ctx))
+	}
+	report := *h.cached
+	// callers may modify the report, so they each get their own resources
+	report.Resources = make(map[string]ResourceReport, len(h.cached.Resources))
+	for name, resource := range h.cached.Resources {
+		report.Resources[name] = resource
+	}
+	if h.shuttingDown.Load() {
+		report.Status = StatusUnavailable
+	}
+	return &report
+}
+
+// check checks every resource concurrently
+func (h *Handler) check(ctx context.Context) *Report {
+	status := h.connections.Status()
+	names := make([]string, 0, len(status))
+	for name := range status {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	reports := make([]ResourceReport, len(names))
+	wg := sync.WaitGroup{}
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reports[i] = h.checkResource(ctx, name, status[name])
+		}()
+	}
+	wg.Wait()
+
+	report := &Report{Status: StatusOK, CheckedAt: time.Now(), Resources: make(map[string]ResourceReport, len(names))}
+	for i, name := range names {
+		report.Resources[name] = reports[i]
+		switch {
+		case reports[i].Status == StatusOK:
+		case reports[i].Optional && report.Status == StatusOK:
+			report.Status = StatusDegraded
+		case !reports[i].Optional:
+			report.Status = StatusUnavailable
+		}
+	}
+	return report
+}
+
+// checkResource checks a resource within the timeout.
+// Idle resources are connected on first use in lazy mode, so they are not checked.
+func (h *Handler) checkResource(ctx context.Context, name string, status opener.ResourceStatus) ResourceReport {
+	report := ResourceReport{
+		Status:   StatusOK,
+		State:    status.State.String(),
+		Optional: status.Optional,
+	}
+	if status.State == opener.StateIdle {
+		return report
+	}
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	start := time.Now()
+	err := h.connections.Check(ctx, name)
+	report.Latency = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		report.Status = StatusUnavailable
+		report.Error = err.Error()
+	}
+	return report
+}
+
+// writeReport writes a report as JSON, with 503 Service Unavailable if it is unavailable
+func writeReport(w http.ResponseWriter, report *Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status == StatusUnavailable {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,189 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/explodes/ezconfig/opener"
+)
+
+var errUnhealthy = errors.New("unhealthy")
+
+func init() {
+	opener.SetLogger(slog.New(slog.DiscardHandler))
+}
+
+// nopCloser is a connection to a fake resource
+type nopCloser struct{}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// fakeResource builds a resource whose check fails when healthy is not set
+func fakeResource(name string, healthy *atomic.Bool, checks *atomic.Int32) opener.Resource {
+	return opener.Resource{
+		Name: name,
+		Connect: func(ctx context.Context, config interface{}, deps *opener.Connections) (io.Closer, error) {
+			return nopCloser{}, nil
+		},
+		Check: func(ctx context.Context, conn io.Closer) error {
+			if checks != nil {
+				checks.Add(1)
+			}
+			if !healthy.Load() {
+				return errUnhealthy
+			}
+			return nil
+		},
+	}
+}
+
+// connect connects to the resources
+func connect(t *testing.T, resources ...opener.Resource) *opener.Connections {
+	t.Helper()
+	o := opener.New()
+	for _, resource := range resources {
+		o.WithResource(resource)
+	}
+	connections, err := o.Connect()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	t.Cleanup(func() { connections.Close() })
+	return connections
+}
+
+// serve serves a request and decodes the report
+func serve(t *testing.T, handler http.Handler) (int, *Report) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Unexpected content type %q", ct)
+	}
+	report := &Report{}
+	if err := json.Unmarshal(rec.Body.Bytes(), report); err != nil {
+		t.Fatalf("Response is not json: %v", err)
+	}
+	return rec.Code, report
+}
+
+func TestHandler_Ready(t *testing.T) {
+	var healthy, optionalHealthy atomic.Bool
+	healthy.Store(true)
+	optionalHealthy.Store(true)
+	analytics := fakeResource("analytics", &optionalHealthy, nil)
+	analytics.Optional = true
+	h := New(connect(t, fakeResource("database", &healthy, nil), analytics)).WithCacheTTL(0)
+
+	code, report := serve(t, h.Ready())
+	if code != http.StatusOK || report.Status != StatusOK {
+		t.Fatalf("Expected ok, got %d %+v", code, report)
+	}
+	database := report.Resources["database"]
+	if database.Status != StatusOK || database.State != "connected" || database.Optional || database.Latency < 0 {
+		t.Fatalf("Unexpected database report %+v", database)
+	}
+
+	optionalHealthy.Store(false)
+	code, report = serve(t, h.Ready())
+	if code != http.StatusOK || report.Status != StatusDegraded || report.Resources["analytics"].Error != errUnhealthy.Error() {
+		t.Fatalf("Expected degraded, got %d %+v", code, report)
+	}
+
+	healthy.Store(false)
+	code, report = serve(t, h)
+	if code != http.StatusServiceUnavailable || report.Status != StatusUnavailable {
+		t.Fatalf("Expected unavailable, got %d %+v", code, report)
+	}
+}
+
+func TestHandler_lazy(t *testing.T) {
+	var healthy atomic.Bool
+	connections, err := opener.New().WithLazy().WithResource(fakeResource("database", &healthy, nil)).Connect()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer connections.Close()
+	h := New(connections).WithCacheTTL(0)
+
+	// idle resources are connected on first use, so they do not fail readiness
+	code, report := serve(t, h.Ready())
+	if code != http.StatusOK || report.Status != StatusOK || report.Resources["database"].State != "idle" {
+		t.Fatalf("Expected ok, got %d %+v", code, report)
+	}
+
+	if _, err := connections.GetContext(context.Background(), "database"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if code, _ := serve(t, h.Ready()); code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected connected resources to be checked, got %d", code)
+	}
+}
+
+func TestHandler_timeout(t *testing.T) {
+	slow := opener.Resource{
+		Name: "database",
+		Connect: func(ctx context.Context, config interface{}, deps *opener.Connections) (io.Closer, error) {
+			return nopCloser{}, nil
+		},
+		Check: func(ctx context.Context, conn io.Closer) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}
+	h := New(connect(t, slow)).WithTimeout(time.Millisecond)
+	code, report := serve(t, h.Ready())
+	if code != http.StatusServiceUnavailable || report.Resources["database"].Error != context.DeadlineExceeded.Error() {
+		t.Fatalf("Expected the check to time out, got %d %+v", code, report)
+	}
+}
+
+func TestHandler_cache(t *testing.T) {
+	var healthy atomic.Bool
+	var checks atomic.Int32
+	healthy.Store(true)
+	h := New(connect(t, fakeResource("database", &healthy, &checks))).WithCacheTTL(time.Hour)
+	serve(t, h.Ready())
+	serve(t, h.Ready())
+	if checks.Load() != 1 {
+		t.Fatalf("Expected one check, got %d", checks.Load())
+	}
+
+	// modifying a report does not modify the cached one
+	delete(h.Report(context.Background()).Resources, "database")
+	if _, ok := h.Report(context.Background()).Resources["database"]; !ok {
+		t.Fatal("Modifying a report changed the cached report")
+	}
+}
+
+func TestHandler_Live(t *testing.T) {
+	var healthy atomic.Bool
+	h := New(connect(t, fakeResource("database", &healthy, nil)))
+	code, report := serve(t, h.Live())
+	if code != http.StatusOK || report.Status != StatusOK || len(report.Resources) != 0 {
+		t.Fatalf("Expected live, got %d %+v", code, report)
+	}
+}
+
+func TestHandler_Shutdown(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	h := New(connect(t, fakeResource("database", &healthy, nil)))
+	h.Shutdown(context.Background())
+	if code, _ := serve(t, h.Ready()); code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected unavailable while shutting down, got %d", code)
+	}
+	if code, _ := serve(t, h.Live()); code != http.StatusOK {
+		t.Fatalf("Expected live while shutting down, got %d", code)
+	}
+}
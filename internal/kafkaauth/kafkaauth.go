@@ -0,0 +1,43 @@
+// Package kafkaauth secures and authenticates the connections of the kafka
+// producer and consumer with their [producer.tls] and [producer.sasl] settings,
+// or the equivalent [consumer] sections
+package kafkaauth
+
+import (
+	"log/slog"
+
+	"github.com/Shopify/sarama"
+	"github.com/explodes/ezconfig"
+	"github.com/explodes/ezconfig/internal/tlsconf"
+)
+
+// Configure secures and authenticates the connections of config with the
+// settings that are enabled
+func Configure(config *sarama.Config, tlsSettings ezconfig.TLSConfig, saslSettings ezconfig.SASLConfig) error {
+	if tlsSettings.Enabled() {
+		tlsConfig, err := tlsconf.Load(tlsSettings)
+		if err != nil {
+			return err
+		}
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+	if saslSettings.Enabled() {
+		ConfigureSASL(config, saslSettings)
+	}
+	return nil
+}
+
+// Validate makes sure the tls files and sasl credentials of the settings
+// that are enabled are present, logging warnings with logger
+func Validate(tlsSettings ezconfig.TLSConfig, saslSettings ezconfig.SASLConfig, logger *slog.Logger) error {
+	if tlsSettings.Enabled() {
+		if err := tlsconf.Validate(tlsSettings); err != nil {
+			return err
+		}
+	}
+	if saslSettings.Enabled() {
+		return ValidateSASL(saslSettings, tlsSettings.Enabled(), logger)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package kafkaauth
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/explodes/ezconfig"
+)
+
+func TestConfigure(t *testing.T) {
+	mechanisms := map[string]sarama.SASLMechanism{
+		"":              sarama.SASLTypePlaintext,
+		"PLAIN":         sarama.SASLTypePlaintext,
+		"SCRAM-SHA-256": sarama.SASLTypeSCRAMSHA256,
+		"SCRAM-SHA-512": sarama.SASLTypeSCRAMSHA512,
+	}
+	for name, expected := range mechanisms {
+		config := sarama.NewConfig()
+		settings := ezconfig.SASLConfig{Mechanism: name, Username: "orders", Password: "hunter2"}
+		if err := Configure(config, ezconfig.TLSConfig{Enable: true}, settings); err != nil {
+			t.Fatalf("Unexpected error for %q: %v", name, err)
+		}
+		if !config.Net.TLS.Enable || config.Net.TLS.Config == nil {
+			t.Fatalf("Tls not enabled for %q", name)
+		}
+		sasl := config.Net.SASL
+		if !sasl.Enable || sasl.Mechanism != expected || sasl.User != "orders" || sasl.Password != "hunter2" {
+			t.Fatalf("Unexpected sasl settings for %q: %v %v %v", name, sasl.Enable, sasl.Mechanism, sasl.User)
+		}
+		if (sasl.SCRAMClientGeneratorFunc != nil) != strings.HasPrefix(name, "SCRAM") {
+			t.Fatalf("Unexpected scram client for %q", name)
+		}
+	}
+
+	// nothing is enabled without settings
+	config := sarama.NewConfig()
+	if err := Configure(config, ezconfig.TLSConfig{}, ezconfig.SASLConfig{}); err != nil || config.Net.TLS.Enable || config.Net.SASL.Enable {
+		t.Fatalf("Expected tls and sasl to stay disabled, got %v", err)
+	}
+}
+
+func TestValidateSASL(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	invalid := map[string]ezconfig.SASLConfig{
+		"mechanism": {Mechanism: "GSSAPI", Username: "orders", Password: "hunter2"},
+		"username":  {Mechanism: "PLAIN", Password: "hunter2"},
+		"password":  {Mechanism: "SCRAM-SHA-512", Username: "orders"},
+	}
+	for name, settings := range invalid {
+		if err := ValidateSASL(settings, true, logger); err == nil {
+			t.Errorf("Expected an error for a missing or invalid %s", name)
+		}
+	}
+
+	// PLAIN without tls sends the password in clear text
+	var buf bytes.Buffer
+	settings := ezconfig.SASLConfig{Username: "orders", Password: "hunter2"}
+	if err := ValidateSASL(settings, false, slog.New(slog.NewTextHandler(&buf, nil))); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "clear text") || strings.Contains(buf.String(), "hunter2") {
+		t.Fatalf("Expected a warning without the password, got %q", buf.String())
+	}
+}
+
+func TestScramClient(t *testing.T) {
+	config := sarama.NewConfig()
+	ConfigureSASL(config, ezconfig.SASLConfig{Mechanism: "SCRAM-SHA-256", Username: "orders", Password: "hunter2"})
+	client := config.Net.SASL.SCRAMClientGeneratorFunc()
+	if err := client.Begin("orders", "hunter2", ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	first, err := client.Step("")
+	if err != nil || !strings.HasPrefix(first, "n,,n=orders,r=") {
+		t.Fatalf("Unexpected first message %q (%v)", first, err)
+	}
+	if client.Done() {
+		t.Fatal("Conversation should not be done before the server answers")
+	}
+}
@@ -0,0 +1,90 @@
+package kafkaauth
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/Shopify/sarama"
+	"github.com/explodes/ezconfig"
+	"github.com/xdg-go/scram"
+)
+
+// saslMechanisms are the accepted values for the sasl mechanism
+var saslMechanisms = map[string]sarama.SASLMechanism{
+	"PLAIN":         sarama.SASLTypePlaintext,
+	"SCRAM-SHA-256": sarama.SASLTypeSCRAMSHA256,
+	"SCRAM-SHA-512": sarama.SASLTypeSCRAMSHA512,
+}
+
+// ValidateSASL makes sure the sasl mechanism is supported and credentials are present,
+// warning logger when the password would be sent in clear text
+func ValidateSASL(settings ezconfig.SASLConfig, tlsEnabled bool, logger *slog.Logger) error {
+	if _, ok := saslMechanisms[settings.Mechanism]; settings.Mechanism != "" && !ok {
+		return fmt.Errorf("Invalid sasl mechanism %q, expected PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512", settings.Mechanism)
+	}
+	if settings.Username == "" {
+		return errors.New("Sasl username not specified")
+	}
+	if settings.Password == "" {
+		return errors.New("Sasl password not specified")
+	}
+	if mechanism(settings) == sarama.SASLTypePlaintext && !tlsEnabled {
+		logger.Warn("sasl PLAIN without tls sends the password to the brokers in clear text",
+			"username", settings.Username)
+	}
+	return nil
+}
+
+// mechanism is the configured SASL mechanism, PLAIN by default
+func mechanism(settings ezconfig.SASLConfig) sarama.SASLMechanism {
+	if settings.Mechanism == "" {
+		return sarama.SASLTypePlaintext
+	}
+	return saslMechanisms[settings.Mechanism]
+}
+
+// ConfigureSASL authenticates the connections of config with the sasl credentials
+func ConfigureSASL(config *sarama.Config, settings ezconfig.SASLConfig) {
+	config.Net.SASL.Enable = true
+	config.Net.SASL.Handshake = true
+	config.Net.SASL.Mechanism = mechanism(settings)
+	config.Net.SASL.User = settings.Username
+	config.Net.SASL.Password = settings.Password
+	switch config.Net.SASL.Mechanism {
+	case sarama.SASLTypeSCRAMSHA256:
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hash: scram.SHA256}
+		}
+	case sarama.SASLTypeSCRAMSHA512:
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hash: scram.SHA512}
+		}
+	}
+}
+
+// scramClient is a sarama.SCRAMClient performing a single SCRAM conversation
+type scramClient struct {
+	hash         scram.HashGeneratorFcn
+	conversation *scram.ClientConversation
+}
+
+// Begin starts the conversation with the credentials
+func (c *scramClient) Begin(username, password, authzID string) error {
+	client, err := c.hash.NewClient(username, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.conversation = client.NewConversation()
+	return nil
+}
+
+// Step answers a challenge from the broker
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+// Done reports whether the conversation is over
+func (c *scramClient) Done() bool {
+	return c.conversation.Done()
+}
@@ -0,0 +1,56 @@
+// Package tlsconf builds the tls.Config of database, producer and consumer
+// connections from their [database.tls], [producer.tls] or [consumer.tls] settings
+package tlsconf
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/explodes/ezconfig"
+)
+
+// Validate makes sure the tls files exist and can be loaded
+func Validate(settings ezconfig.TLSConfig) error {
+	if (settings.CertFile == "") != (settings.KeyFile == "") {
+		return errors.New("Tls cert_file and key_file must be specified together")
+	}
+	for _, file := range []string{settings.CAFile, settings.CertFile, settings.KeyFile} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			return fmt.Errorf("Tls file not readable: %v", err)
+		}
+	}
+	return nil
+}
+
+// Load builds a tls.Config from the tls settings
+func Load(settings ezconfig.TLSConfig) (*tls.Config, error) {
+	config := &tls.Config{
+		// clients fill in the host they connect to when the server name is empty
+		ServerName:         settings.ServerName,
+		InsecureSkipVerify: settings.SkipVerify,
+	}
+	if settings.CAFile != "" {
+		pem, err := os.ReadFile(settings.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %s", settings.CAFile)
+		}
+	}
+	if settings.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,113 @@
+package tlsconf
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/explodes/ezconfig"
+)
+
+// writeCertificates writes a self-signed CA along with a client certificate and key
+// to dir, returning their paths
+func writeCertificates(t *testing.T, dir string) (caFile, certFile, keyFile string) {
+	t.Helper()
+	writePem := func(name, kind string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0600); err != nil {
+			t.Fatalf("Error writing %s: %v", name, err)
+		}
+		return path
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ezconfig test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDer, err := x509.CreateCertificate(rand.Reader, clientTemplate, caTemplate, &clientKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return writePem("ca.pem", "CERTIFICATE", caDer),
+		writePem("client.pem", "CERTIFICATE", clientDer),
+		writePem("client.key", "EC PRIVATE KEY", keyDer)
+}
+
+func TestLoad(t *testing.T) {
+	caFile, certFile, keyFile := writeCertificates(t, t.TempDir())
+	settings := ezconfig.TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: "broker.internal"}
+	if err := Validate(settings); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	config, err := Load(settings)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.RootCAs == nil || len(config.Certificates) != 1 || config.ServerName != "broker.internal" || config.InsecureSkipVerify {
+		t.Fatal("Certificates not loaded")
+	}
+
+	// the system certificate authorities are used without a ca file
+	config, err = Load(ezconfig.TLSConfig{Enable: true, SkipVerify: true})
+	if err != nil || config.RootCAs != nil || !config.InsecureSkipVerify {
+		t.Fatalf("Expected tls with the system certificate authorities, got %v", err)
+	}
+}
+
+func TestLoad_errors(t *testing.T) {
+	dir := t.TempDir()
+	caFile, certFile, keyFile := writeCertificates(t, dir)
+	invalid := map[string]ezconfig.TLSConfig{
+		"missing ca file":         {CAFile: filepath.Join(dir, "missing.pem")},
+		"mismatched key":          {CertFile: caFile, KeyFile: keyFile},
+		"certificate without key": {CertFile: certFile},
+		"ca file without pem":     {CAFile: keyFile},
+	}
+	for name, settings := range invalid {
+		err := Validate(settings)
+		if err == nil {
+			_, err = Load(settings)
+		}
+		if err == nil {
+			t.Errorf("Expected an error for a %s", name)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultGracePeriod is how long shutdown hooks are given to finish, see WithGracePeriod
+const DefaultGracePeriod = 30 * time.Second
+
+// ShutdownFunc releases a resource, giving up once ctx is done
+type ShutdownFunc func(ctx context.Context) error
+
+// Manager runs shutdown hooks when the process is asked to stop.
+// Hooks run one at a time in the reverse of the order they were registered in,
+// so resources registered first, such as connections, are released last.
+//
+//	manager := lifecycle.New().WithGracePeriod(10 * time.Second)
+//	ctx, stop := manager.Context(context.Background())
+//	defer stop()
+//	connections, err := opener.New().WithDatabase(&config.DbConfig).ConnectContext(ctx)
+//	...
+//	manager.OnClose("connections", connections)
+//	manager.OnShutdown("server", server.Shutdown)
+//	go server.ListenAndServe()
+//	if err := manager.Wait(context.Background()); err != nil {
+//		log.Fatal(err)
+//	}
+type Manager struct {
+	mu      sync.Mutex
+	hooks   []hook
+	grace   time.Duration
+	signals []os.Signal
+	logger  *slog.Logger
+}
+
+// hook is a named ShutdownFunc
+type hook struct {
+	name     string
+	shutdown ShutdownFunc
+}
+
+// New creates a Manager listening for SIGINT and SIGTERM with the default grace period
+func New() *Manager {
+	return &Manager{
+		grace:   DefaultGracePeriod,
+		signals: []os.Signal{os.Interrupt, syscall.SIGTERM},
+	}
+}
+
+// WithGracePeriod sets how long the shutdown hooks are given to finish, together
+func (m *Manager) WithGracePeriod(grace time.Duration) *Manager {
+	m.grace = grace
+	return m
+}
+
+// WithSignals sets the signals that start shutting down
+func (m *Manager) WithSignals(signals ...os.Signal) *Manager {
+	m.signals = signals
+	return m
+}
+
+// WithLogger sets the logger used while shutting down, slog.Default() if not set
+func (m *Manager) WithLogger(logger *slog.Logger) *Manager {
+	m.logger = logger
+	return m
+}
+
+// OnShutdown registers a hook to run while shutting down
+func (m *Manager) OnShutdown(name string, shutdown ShutdownFunc) *Manager {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, hook{name: name, shutdown: shutdown})
+	return m
+}
+
+// OnClose registers an io.Closer to close while shutting down.
+// Closers with a CloseContext(ctx) method, such as opener.Connections, are closed with it.
+func (m *Manager) OnClose(name string, closer io.Closer) *Manager {
+	if c, ok := closer.(interface {
+		CloseContext(ctx context.Context) error
+	}); ok {
+		return m.OnShutdown(name, c.CloseContext)
+	}
+	return m.OnShutdown(name, func(ctx context.Context) error {
+		return closer.Close()
+	})
+}
+
+// Context returns a copy of parent that is done once one of the signals is received,
+// to stop work such as connecting when the process is asked to stop before Wait is called
+func (m *Manager) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(parent, m.signals...)
+}
+
+// Wait blocks until one of the signals is received or ctx is done, then shuts down
+func (m *Manager) Wait(ctx context.Context) error {
+	ctx, stop := m.Context(ctx)
+	<-ctx.Done()
+	stop()
+	m.log().Info("Shutting down", "grace_period", m.grace)
+	return m.Shutdown(context.Background())
+}
+
+// Shutdown runs every hook in the reverse of the order they were registered in,
+// within the grace period. A hook that is still running when the grace period ends
+// is abandoned, as are the hooks after it.
+// Every error received is returned, joined. Each is a *HookError naming the hook
+// that failed, and wraps context.DeadlineExceeded for hooks that did not finish in time.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	hooks := append([]hook(nil), m.hooks...)
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, m.grace)
+	defer cancel()
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := m.run(ctx, hooks[i]); err != nil {
+			errs = append(errs, &HookError{Hook: hooks[i].name, Err: err})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// run runs a hook, giving up once ctx is done
+func (m *Manager) run(ctx context.Context, h hook) error {
+	if ctx.Err() != nil {
+		return m.abandoned(ctx, h)
+	}
+	start := time.Now()
+	finished := make(chan error, 1)
+	go func() {
+		finished <- h.shutdown(ctx)
+	}()
+	select {
+	case err := <-finished:
+		if err != nil {
+			m.log().Error("Shutdown failed", "hook", h.name, "duration", time.Since(start), "error", err)
+			return err
+		}
+		m.log().Debug("Shut down", "hook", h.name, "duration", time.Since(start))
+		return nil
+	case <-ctx.Done():
+		return m.abandoned(ctx, h)
+	}
+}
+
+// abandoned reports a hook that did not finish before ctx was done
+func (m *Manager) abandoned(ctx context.Context, h hook) error {
+	err := fmt.Errorf("Shutdown did not finish: %w", context.Cause(ctx))
+	m.log().Error("Shutdown abandoned", "hook", h.name, "error", err)
+	return err
+}
+
+// log returns the logger to use
+func (m *Manager) log() *slog.Logger {
+	if m.logger == nil {
+		return slog.Default()
+	}
+	return m.logger
+}
+
+// HookError is an error running a shutdown hook.
+// Use errors.As to find out which hook failed, or HookErrors to list every hook that failed.
+type HookError struct {
+	Hook string
+	Err  error
+}
+
+// Error describes the failure, prefixed with the hook name
+func (e *HookError) Error() string {
+	return e.Hook + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// HookErrors lists every *HookError joined in err
+func HookErrors(err error) []*HookError {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		var hookErr *HookError
+		if errors.As(err, &hookErr) {
+			return []*HookError{hookErr}
+		}
+		return nil
+	}
+	var result []*HookError
+	for _, err := range joined.Unwrap() {
+		result = append(result, HookErrors(err)...)
+	}
+	return result
+}
@@ -0,0 +1,125 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// quiet creates a Manager that does not log
+func quiet() *Manager {
+	return New().WithLogger(slog.New(slog.DiscardHandler))
+}
+
+// recorder records the order shutdown hooks run in
+type recorder []string
+
+func (r *recorder) hook(name string, err error) ShutdownFunc {
+	return func(ctx context.Context) error {
+		*r = append(*r, name)
+		return err
+	}
+}
+
+func TestManager_Shutdown_order(t *testing.T) {
+	var order recorder
+	m := quiet().
+		OnShutdown("database", order.hook("database", nil)).
+		OnShutdown("producer", order.hook("producer", nil)).
+		OnShutdown("server", order.hook("server", nil))
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := recorder{"server", "producer", "database"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Fatalf("Unexpected order %v", order)
+	}
+}
+
+func TestManager_Shutdown_errors(t *testing.T) {
+	var order recorder
+	errFlush := errors.New("flush failed")
+	m := quiet().
+		OnShutdown("database", order.hook("database", nil)).
+		OnShutdown("producer", order.hook("producer", errFlush))
+	err := m.Shutdown(context.Background())
+	if !errors.Is(err, errFlush) {
+		t.Fatalf("Expected the flush error, got %v", err)
+	}
+	failed := HookErrors(err)
+	if len(failed) != 1 || failed[0].Hook != "producer" {
+		t.Fatalf("Expected producer to fail, got %v", failed)
+	}
+	if len(order) != 2 {
+		t.Fatal("Hooks after a failure should still run")
+	}
+}
+
+func TestManager_Shutdown_gracePeriod(t *testing.T) {
+	var order recorder
+	release := make(chan struct{})
+	defer close(release)
+	m := quiet().
+		WithGracePeriod(10*time.Millisecond).
+		OnShutdown("database", order.hook("database", nil)).
+		OnShutdown("producer", func(ctx context.Context) error {
+			<-release
+			return nil
+		})
+
+	start := time.Now()
+	err := m.Shutdown(context.Background())
+	if time.Since(start) > time.Second {
+		t.Fatal("Shutdown did not respect the grace period")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected a deadline error, got %v", err)
+	}
+	failed := HookErrors(err)
+	if len(failed) != 2 || failed[0].Hook != "producer" || failed[1].Hook != "database" {
+		t.Fatalf("Expected producer and database to time out, got %v", failed)
+	}
+	if len(order) != 0 {
+		t.Fatal("Hooks after the grace period should not run")
+	}
+}
+
+// contextCloser is closed with CloseContext
+type contextCloser struct {
+	ctx context.Context
+}
+
+func (c *contextCloser) Close() error {
+	return errors.New("Close should not be called")
+}
+
+func (c *contextCloser) CloseContext(ctx context.Context) error {
+	c.ctx = ctx
+	return nil
+}
+
+func TestManager_OnClose(t *testing.T) {
+	closer := &contextCloser{}
+	if err := quiet().OnClose("connections", closer).Shutdown(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := closer.ctx.Deadline(); !ok {
+		t.Fatal("Expected CloseContext to be given the grace period")
+	}
+}
+
+func TestManager_Wait(t *testing.T) {
+	var order recorder
+	m := quiet().OnShutdown("server", order.hook("server", nil))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.Wait(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(order) != 1 {
+		t.Fatal("Expected shutdown hooks to run")
+	}
+}
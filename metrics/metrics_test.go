@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("test_total", "Things counted.", "resource")
+	c.Inc("database")
+	c.Add(2, `we"ird`)
+	h := r.Histogram("test_seconds", "Time taken.", []float64{1, 0.5}, "resource")
+	h.Observe(0.25, "database")
+	h.Observe(0.75, "database")
+	h.Observe(3, "database")
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP test_seconds Time taken.
+# TYPE test_seconds histogram
+test_seconds_bucket{resource="database",le="0.5"} 1
+test_seconds_bucket{resource="database",le="1"} 2
+test_seconds_bucket{resource="database",le="+Inf"} 3
+test_seconds_sum{resource="database"} 4
+test_seconds_count{resource="database"} 3
+# HELP test_total Things counted.
+# TYPE test_total counter
+test_total{resource="database"} 1
+test_total{resource="we\"ird"} 2
+`
+	if buf.String() != expected {
+		t.Fatalf("Unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestRegistry_reregister(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("test_total", "Things counted.").Inc()
+	r.Counter("test_total", "Things counted.").Inc()
+	if value := r.Counter("test_total", "").Value(); value != 2 {
+		t.Fatalf("Expected 2, got %v", value)
+	}
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("test_total", "Things counted.").Inc()
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Fatalf("Unexpected content type %q", ct)
+	}
+	if rec.Body.String() != "# HELP test_total Things counted.\n# TYPE test_total counter\ntest_total 1\n" {
+		t.Fatalf("Unexpected body %q", rec.Body.String())
+	}
+}
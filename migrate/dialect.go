@@ -0,0 +1,83 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+const (
+	// lockID is the postgres advisory lock key held while migrating ("ezconfig" in hex)
+	lockID = 0x657a636f6e666967
+)
+
+// Dialect describes how migrations are run against a database type
+type Dialect struct {
+	// CreateTable creates the schema_migrations table if it does not exist
+	CreateTable string
+
+	// Placeholder returns the bind parameter for the nth (1-based) argument
+	Placeholder func(n int) string
+
+	// Transactional is true if schema changes can be rolled back
+	Transactional bool
+
+	// Lock and Unlock, when set, serialize migration runs across processes.
+	// Both are called on the same connection.
+	Lock   func(ctx context.Context, conn *sql.Conn) error
+	Unlock func(ctx context.Context, conn *sql.Conn) error
+}
+
+// dialects holds the registered migration dialects by database type
+var dialects = map[string]*Dialect{
+	"postgres": {
+		CreateTable: `CREATE TABLE IF NOT EXISTS ` + table + ` (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL
+		)`,
+		Placeholder: func(n int) string {
+			return fmt.Sprintf("$%d", n)
+		},
+		Transactional: true,
+		Lock: func(ctx context.Context, conn *sql.Conn) error {
+			_, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID)
+			return err
+		},
+		Unlock: func(ctx context.Context, conn *sql.Conn) error {
+			_, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, lockID)
+			return err
+		},
+	},
+	"sqlite3": {
+		CreateTable: `CREATE TABLE IF NOT EXISTS ` + table + ` (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL
+		)`,
+		Placeholder: func(n int) string {
+			return "?"
+		},
+		Transactional: true,
+	},
+}
+
+// RegisterDialect registers the migration dialect for a given database type
+func RegisterDialect(dbType string, dialect *Dialect) {
+	if dialect == nil || dialect.CreateTable == "" || dialect.Placeholder == nil {
+		panic("ezconfig: migration dialect is incomplete")
+	}
+	if (dialect.Lock == nil) != (dialect.Unlock == nil) {
+		panic("ezconfig: migration dialect must set both Lock and Unlock")
+	}
+	if _, dup := dialects[dbType]; dup {
+		panic("ezconfig: RegisterDialect called twice for type " + dbType)
+	}
+	dialects[dbType] = dialect
+}
+
+// getDialect acquires the migration dialect for a database type
+func getDialect(dbType string) (*Dialect, bool) {
+	dialect, ok := dialects[dbType]
+	return dialect, ok
+}
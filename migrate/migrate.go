@@ -0,0 +1,184 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+const (
+	// table is the name of the table used to track applied migrations
+	table = "schema_migrations"
+)
+
+// fileName matches migration files such as 0001_create_users.sql
+var fileName = regexp.MustCompile(`^(\d+)_(.+)\.sql$`)
+
+// Migration is a single versioned SQL script
+type Migration struct {
+	Version int64
+	Name    string
+	SQL     string
+}
+
+// Dir returns a filesystem rooted at a directory of migration files on disk
+func Dir(dir string) fs.FS {
+	return os.DirFS(dir)
+}
+
+// Load reads the migrations in the root of fsys, sorted by version.
+// Migration files are named <version>_<name>.sql, other files are ignored.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	var migrations []Migration
+	seen := make(map[int64]string)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid migration version in %s: %v", entry.Name(), err)
+		}
+		if other, dup := seen[version]; dup {
+			return nil, fmt.Errorf("Duplicate migration version %d in %s and %s", version, other, entry.Name())
+		}
+		seen[version] = entry.Name()
+		contents, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{
+			Version: version,
+			Name:    match[2],
+			SQL:     string(contents),
+		})
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Up loads the migrations in fsys and applies the ones that have not been applied yet
+func Up(db *sql.DB, dbType string, fsys fs.FS) error {
+	return UpContext(context.Background(), db, dbType, fsys)
+}
+
+// UpContext is Up with a context that bounds the whole run
+func UpContext(ctx context.Context, db *sql.DB, dbType string, fsys fs.FS) error {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return err
+	}
+	return ApplyContext(ctx, db, dbType, migrations)
+}
+
+// Apply applies, in version order, the migrations that are not yet recorded in the
+// schema_migrations table. Each migration runs in its own transaction when the
+// database supports transactional DDL. Concurrent runs are serialized with a lock
+// when the database supports one.
+func Apply(db *sql.DB, dbType string, migrations []Migration) error {
+	return ApplyContext(context.Background(), db, dbType, migrations)
+}
+
+// ApplyContext is Apply with a context that bounds the whole run
+func ApplyContext(ctx context.Context, db *sql.DB, dbType string, migrations []Migration) error {
+	dialect, ok := getDialect(dbType)
+	if !ok {
+		return fmt.Errorf("No migration dialect for database type %s", dbType)
+	}
+
+	// pin a single connection so that session level locks are held for the whole run
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if dialect.Lock != nil {
+		if err := dialect.Lock(ctx, conn); err != nil {
+			return fmt.Errorf("Unable to acquire migration lock: %v", err)
+		}
+		// unlock even if ctx is done, the lock must not outlive the run
+		defer dialect.Unlock(context.Background(), conn)
+	}
+
+	if _, err := conn.ExecContext(ctx, dialect.CreateTable); err != nil {
+		return fmt.Errorf("Unable to create %s: %v", table, err)
+	}
+	applied, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	record := fmt.Sprintf("INSERT INTO %s (version, name, applied_at) VALUES (%s, %s, CURRENT_TIMESTAMP)",
+		table, dialect.Placeholder(1), dialect.Placeholder(2))
+	for _, migration := range sorted {
+		if applied[migration.Version] {
+			continue
+		}
+		if err := apply(ctx, conn, dialect, record, migration); err != nil {
+			return fmt.Errorf("Migration %d_%s failed: %v", migration.Version, migration.Name, err)
+		}
+	}
+	return nil
+}
+
+// appliedVersions reads the versions recorded in the schema_migrations table
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]bool, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version FROM "+table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int64]bool)
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+// apply runs a single migration and records its version
+func apply(ctx context.Context, conn *sql.Conn, dialect *Dialect, record string, migration Migration) error {
+	if !dialect.Transactional {
+		if _, err := conn.ExecContext(ctx, migration.SQL); err != nil {
+			return err
+		}
+		_, err := conn.ExecContext(ctx, record, migration.Version, migration.Name)
+		return err
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, migration.SQL); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, migration.Version, migration.Name); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
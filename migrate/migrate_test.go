@@ -0,0 +1,102 @@
+package migrate
+
+import (
+	"database/sql"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openTestDb(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: is a new database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func appliedCount(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_add_email.sql":    {Data: []byte(`ALTER TABLE users ADD COLUMN email TEXT`)},
+		"0001_create_users.sql": {Data: []byte(`CREATE TABLE users (id INTEGER PRIMARY KEY)`)},
+		"README.md":             {Data: []byte(`ignored`)},
+	}
+	migrations, err := Load(fsys)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(migrations) != 2 {
+		t.Fatalf("Expected 2 migrations, got %d", len(migrations))
+	}
+	if migrations[0].Version != 1 || migrations[0].Name != "create_users" || migrations[1].Version != 2 {
+		t.Fatalf("Unexpected migrations %+v", migrations)
+	}
+
+	fsys["02_duplicate.sql"] = &fstest.MapFile{Data: []byte(`SELECT 1`)}
+	if _, err := Load(fsys); err == nil {
+		t.Fatal("Expected an error for duplicate versions")
+	}
+}
+
+func TestUp_sqlite(t *testing.T) {
+	db := openTestDb(t)
+	fsys := fstest.MapFS{
+		"0001_create_users.sql": {Data: []byte(`CREATE TABLE users (id INTEGER PRIMARY KEY);`)},
+	}
+	if err := Up(db, "sqlite3", fsys); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// applying again is a no-op
+	if err := Up(db, "sqlite3", fsys); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if count := appliedCount(t, db); count != 1 {
+		t.Fatalf("Expected 1 applied migration, got %d", count)
+	}
+
+	fsys["0002_add_email.sql"] = &fstest.MapFile{Data: []byte(`ALTER TABLE users ADD COLUMN email TEXT;`)}
+	if err := Up(db, "sqlite3", fsys); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO users (email) VALUES ('test@example.com')`); err != nil {
+		t.Fatalf("Migration not applied: %v", err)
+	}
+}
+
+func TestUp_rollback(t *testing.T) {
+	db := openTestDb(t)
+	fsys := fstest.MapFS{
+		"0001_create_users.sql": {Data: []byte(`CREATE TABLE users (id INTEGER PRIMARY KEY);`)},
+		"0002_broken.sql":       {Data: []byte(`CREATE TABLE orders (id INTEGER); NOT SQL;`)},
+	}
+	if err := Up(db, "sqlite3", fsys); err == nil {
+		t.Fatal("Expected an error from a broken migration")
+	}
+	if count := appliedCount(t, db); count != 1 {
+		t.Fatalf("Expected 1 applied migration, got %d", count)
+	}
+	if _, err := db.Exec(`SELECT id FROM orders`); err == nil {
+		t.Fatal("Broken migration was not rolled back")
+	}
+}
+
+func TestApply_unknownDialect(t *testing.T) {
+	db := openTestDb(t)
+	if err := Apply(db, "oracle", nil); err == nil {
+		t.Fatal("Expected an error for an unknown database type")
+	}
+}
@@ -0,0 +1,557 @@
+package opener
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/explodes/ezconfig/backoff"
+	"github.com/explodes/ezconfig/consumer"
+	"github.com/explodes/ezconfig/producer"
+)
+
+// State is the state of a resource's connection
+type State int
+
+const (
+	// StateConnecting resources are being connected for the first time
+	StateConnecting State = iota
+	// StateConnected resources are connected and ready to use
+	StateConnected
+	// StateDegraded resources could not be connected and are being
+	// reconnected in the background, or will be on next use in lazy mode
+	StateDegraded
+	// StateReconnecting resources failed a health check and are being re-initialized
+	StateReconnecting
+	// StateClosed resources have been closed
+	StateClosed
+	// StateIdle resources are connected on first use, see Opener.WithLazy
+	StateIdle
+)
+
+// String returns the name of the state
+func (s State) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateDegraded:
+		return "degraded"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	case StateIdle:
+		return "idle"
+	default:
+		return "unknown"
+	}
+}
+
+// ResourceStatus describes the connection to a resource
+type ResourceStatus struct {
+	State    State
+	Optional bool
+
+	// Err is the error that put a resource in the degraded or reconnecting state
+	Err error
+
+	// Since is when the resource entered its state
+	Since time.Time
+}
+
+// Connections is the result of connecting to multiple sources.
+// Every current connection is available by name through Get or a Handle.
+type Connections struct {
+	// DB is the database connected by Connect. It is not set in lazy mode, and not
+	// replaced when the database is reconnected in the background: use a Handle
+	// to follow reconnects.
+	DB *sql.DB
+
+	// Publisher uses the current producer connection, connecting it first in lazy mode.
+	// While the producer is not connected it fails with a *ResourceError wrapping
+	// ErrNotConnected.
+	Publisher producer.Publisher
+
+	// Producer is Publisher adapted to the original producer.Producer interface
+	Producer producer.Producer
+
+	// Consumer uses the current consumer connection, as Publisher does
+	Consumer consumer.Consumer
+
+	// publisher is Publisher, given to new producer connections, see add
+	publisher *currentPublisher
+
+	// parent holds the current connections of a resource's dependencies, see dependencies
+	parent *Connections
+
+	mu        sync.RWMutex
+	resources map[string]io.Closer
+	status    map[string]*ResourceStatus
+
+	// checks holds the Check function of each resource that has one
+	checks map[string]CheckFunc
+
+	// lazy holds the resources to connect on first use, see Opener.WithLazy
+	lazy map[string]*lazyResource
+
+	// changed is closed, and replaced, whenever a resource is connected
+	changed chan struct{}
+
+	// levels orders the resources by dependency, see levels
+	levels [][]string
+
+	// obs is notified when connections are closed
+	obs *observer
+
+	// ctx bounds background reconnects, cancel stops them
+	ctx        context.Context
+	cancel     context.CancelFunc
+	background sync.WaitGroup
+}
+
+// newConnections creates an empty set of connections
+func newConnections() *Connections {
+	return &Connections{
+		resources: make(map[string]io.Closer),
+		status:    make(map[string]*ResourceStatus),
+		checks:    make(map[string]CheckFunc),
+		lazy:      make(map[string]*lazyResource),
+		changed:   make(chan struct{}),
+	}
+}
+
+// add saves the connection to a resource. A new producer connection is given
+// the OnDelivery handler set on Publisher. c.mu must be held.
+func (c *Connections) add(name string, conn io.Closer) {
+	c.resources[name] = conn
+	if name == ProducerName && c.publisher != nil {
+		c.publisher.apply(conn)
+	}
+}
+
+// logger is the logger of the opener that made the connections
+func (c *Connections) logger() *slog.Logger {
+	if c.obs == nil {
+		return defaultLogger()
+	}
+	return c.obs.log
+}
+
+// setStatus records the state of a resource, returning the StateChanged event to
+// emit once c.mu is released. c.mu must be held.
+func (c *Connections) setStatus(name string, optional bool, state State, err error) *Event {
+	previous := StateConnecting
+	if status, ok := c.status[name]; ok {
+		previous = status.State
+	}
+	c.status[name] = &ResourceStatus{State: state, Optional: optional, Err: err, Since: time.Now()}
+	if state == StateConnected {
+		close(c.changed)
+		c.changed = make(chan struct{})
+	}
+	if previous == state {
+		return nil
+	}
+	return &Event{Type: StateChanged, Resource: name, Previous: previous, State: state, Err: err}
+}
+
+// emit sends the events returned by setStatus
+func (c *Connections) emit(events ...*Event) {
+	for _, event := range events {
+		if event != nil && c.obs != nil {
+			c.obs.emit(*event)
+		}
+	}
+}
+
+// transition records the state of a resource
+func (c *Connections) transition(resource *Resource, state State, err error) {
+	c.mu.Lock()
+	event := c.setStatus(resource.Name, resource.Optional, state, err)
+	c.mu.Unlock()
+	c.emit(event)
+}
+
+// connected records that a resource was connected while starting up
+func (c *Connections) connected(resource *Resource, conn io.Closer) {
+	c.mu.Lock()
+	c.add(resource.Name, conn)
+	event := c.setStatus(resource.Name, resource.Optional, StateConnected, nil)
+	c.mu.Unlock()
+	c.emit(event)
+}
+
+// swap replaces the connection to a resource, closing the previous one.
+// The new connection is closed instead if the connections are being closed.
+func (c *Connections) swap(resource *Resource, conn io.Closer) {
+	c.mu.Lock()
+	if c.ctx.Err() != nil {
+		c.mu.Unlock()
+		conn.Close()
+		return
+	}
+	previous, replaced := c.resources[resource.Name]
+	c.add(resource.Name, conn)
+	event := c.setStatus(resource.Name, resource.Optional, StateConnected, nil)
+	c.mu.Unlock()
+	c.emit(event)
+
+	c.obs.log.Info("Reconnected", "service", resource.Name, "address", resource.Address)
+	if replaced {
+		if err := c.resourceCloser(context.Background(), resource.Name, previous).Close(); err != nil {
+			c.obs.log.Warn("Unable to close replaced connection", "service", resource.Name, "error", err)
+		}
+	}
+}
+
+// dependencies collects the connections to the named resources, with DB, Publisher,
+// Producer and Consumer set for the built-in resources. Get and Handles on the result
+// return the current connections, which may have replaced the collected ones.
+// If any is not connected it returns nil and a channel that is closed
+// when another resource is connected.
+func (c *Connections) dependencies(names []string) (*Connections, <-chan struct{}) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	deps := newConnections()
+	deps.parent = c
+	for _, name := range names {
+		conn, ok := c.resources[name]
+		if !ok {
+			return nil, c.changed
+		}
+		deps.resources[name] = conn
+		switch name {
+		case DatabaseName:
+			deps.DB, _ = conn.(*sql.DB)
+		case ProducerName:
+			deps.Publisher, _ = conn.(producer.Publisher)
+			if c.Publisher != nil {
+				deps.Publisher = c.Publisher
+			}
+			if deps.Publisher != nil {
+				deps.Producer = producer.ToProducer(deps.Publisher, c.logger())
+			}
+		case ConsumerName:
+			deps.Consumer, _ = conn.(consumer.Consumer)
+			if c.Consumer != nil {
+				deps.Consumer = c.Consumer
+			}
+		}
+	}
+	return deps, nil
+}
+
+// reconnectFunc connects to a resource given its dependencies
+type reconnectFunc func(ctx context.Context, deps *Connections) (io.Closer, error)
+
+// supervision configures how a resource is kept connected in the background
+type supervision struct {
+	// connect re-initializes the resource with its retry policy
+	connect reconnectFunc
+
+	// reconnect is how often a degraded resource is reconnected
+	reconnect time.Duration
+
+	// check is how often a connected resource is health checked, zero to never check it
+	check time.Duration
+}
+
+// startWatch keeps a resource connected in the background, see watch
+func (c *Connections) startWatch(resource *Resource, s supervision) {
+	c.background.Add(1)
+	go c.watch(resource, s)
+}
+
+// watch keeps a resource connected until the connections are closed.
+// A degraded resource is reconnected every s.reconnect, and when health checks
+// are enabled a connected resource is checked every s.check and re-initialized
+// when a check fails.
+func (c *Connections) watch(resource *Resource, s supervision) {
+	defer c.background.Done()
+	for {
+		if _, ok := c.Get(resource.Name); !ok {
+			if wait(c.ctx, backoff.Constant(s.reconnect), 0) != nil || !c.reconnect(resource, s) {
+				return
+			}
+		}
+		if s.check <= 0 || resource.Check == nil {
+			return
+		}
+		if wait(c.ctx, backoff.Constant(s.check), 0) != nil {
+			return
+		}
+		if err := c.check(resource, s.check); err != nil {
+			if c.ctx.Err() != nil {
+				return
+			}
+			c.obs.log.Warn("Health check failed, reconnecting",
+				"service", resource.Name,
+				"error", err,
+				"address", resource.Address)
+			c.transition(resource, StateReconnecting, err)
+			if !c.reconnect(resource, s) {
+				return
+			}
+		}
+	}
+}
+
+// check checks the health of a resource's connection, giving up after timeout
+func (c *Connections) check(resource *Resource, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(c.ctx, timeout)
+	defer cancel()
+	return c.Check(ctx, resource.Name)
+}
+
+// reconnect connects a resource once all of its dependencies are connected,
+// trying again every s.reconnect until it succeeds or the connections are closed.
+// The new connection replaces any existing one. It returns false if the
+// connections were closed first.
+func (c *Connections) reconnect(resource *Resource, s supervision) bool {
+	for {
+		deps, changed := c.dependencies(resource.DependsOn)
+		if deps == nil {
+			select {
+			case <-changed:
+				continue
+			case <-c.ctx.Done():
+				return false
+			}
+		}
+		conn, err := s.connect(c.ctx, deps)
+		if err == nil {
+			c.swap(resource, conn)
+			return true
+		}
+		if c.ctx.Err() != nil {
+			return false
+		}
+		c.transition(resource, StateDegraded, err)
+		if wait(c.ctx, backoff.Constant(s.reconnect), 0) != nil {
+			return false
+		}
+	}
+}
+
+// Status returns the status of every resource by name
+func (c *Connections) Status() map[string]ResourceStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	status := make(map[string]ResourceStatus, len(c.status))
+	for name, s := range c.status {
+		status[name] = *s
+	}
+	return status
+}
+
+// Check checks the health of the named resource with its Check function.
+// Resources without one are healthy as long as they are connected.
+func (c *Connections) Check(ctx context.Context, name string) error {
+	conn, ok := c.Get(name)
+	if !ok {
+		return &ResourceError{Resource: name, Err: ErrNotConnected}
+	}
+	if check := c.checks[name]; check != nil {
+		return check(ctx, conn)
+	}
+	return nil
+}
+
+// Degraded reports whether any resource is degraded or reconnecting
+func (c *Connections) Degraded() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, s := range c.status {
+		if s.State == StateDegraded || s.State == StateReconnecting {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns the connection to the named resource, if it is connected.
+// It does not connect lazy resources, see GetContext.
+func (c *Connections) Get(name string) (io.Closer, bool) {
+	if c.parent != nil {
+		return c.parent.Get(name)
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	conn, ok := c.resources[name]
+	return conn, ok
+}
+
+// Get returns the connection to the named resource as a T,
+// connecting it first in lazy mode
+//
+//	client, err := opener.Get[*redis.Client](connections, "redis")
+func Get[T any](c *Connections, name string) (T, error) {
+	return GetContext[T](context.Background(), c, name)
+}
+
+// GetContext returns the connection to the named resource as a T,
+// connecting it first in lazy mode. Retrying stops as soon as ctx is done.
+func GetContext[T any](ctx context.Context, c *Connections, name string) (T, error) {
+	var zero T
+	conn, err := c.GetContext(ctx, name)
+	if err != nil {
+		return zero, err
+	}
+	typed, ok := conn.(T)
+	if !ok {
+		return zero, fmt.Errorf("Resource %s is a %T, not a %T", name, conn, zero)
+	}
+	return typed, nil
+}
+
+// Handle is a stable reference to a resource, whose connection may be replaced
+// when it is reconnected in the background
+//
+//	db := opener.NewHandle[*sql.DB](connections, opener.DatabaseName)
+//	...
+//	conn, err := db.Get()
+type Handle[T any] struct {
+	c    *Connections
+	name string
+}
+
+// NewHandle creates a Handle to the named resource
+func NewHandle[T any](c *Connections, name string) *Handle[T] {
+	return &Handle[T]{c: c, name: name}
+}
+
+// Get returns the current connection to the resource, connecting it first in lazy mode
+func (h *Handle[T]) Get() (T, error) {
+	return Get[T](h.c, h.name)
+}
+
+// GetContext returns the current connection to the resource, connecting it first
+// in lazy mode. Retrying stops as soon as ctx is done.
+func (h *Handle[T]) GetContext(ctx context.Context) (T, error) {
+	return GetContext[T](ctx, h.c, h.name)
+}
+
+// Close stops supervising and reconnecting resources, closes all active connections
+// and returns every error received, joined.
+// Each is a *ResourceError naming the resource that failed to close.
+// Resources are closed before the resources they depend on, independent
+// resources are closed in independent goroutines.
+func (c *Connections) Close() error {
+	return c.CloseContext(context.Background())
+}
+
+// CloseContext is Close, but stops waiting for connections to close once ctx is done.
+// Resources that have not closed by then are reported as a *ResourceError
+// wrapping the cause of ctx being done, and the resources they depend on are not closed.
+func (c *Connections) CloseContext(ctx context.Context) error {
+	c.mu.Lock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.mu.Unlock()
+	stopped := make(chan struct{})
+	go func() {
+		c.background.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+	}
+
+	// closers and handlers may use the connections while they close, so c.mu is
+	// only held to take the resources to close
+	c.mu.Lock()
+	resources := make(map[string]io.Closer, len(c.resources))
+	for name, conn := range c.resources {
+		resources[name] = conn
+	}
+	c.mu.Unlock()
+
+	errs := &errorList{}
+	for i := len(c.levels) - 1; i >= 0; i-- {
+		if ctx.Err() != nil {
+			for _, name := range c.levels[i] {
+				if _, ok := resources[name]; ok {
+					errs.Record(c.closeTimeout(ctx, name))
+				}
+			}
+			continue
+		}
+		errs.Record(c.closeLevel(ctx, resources, c.levels[i]))
+	}
+
+	c.mu.Lock()
+	events := make([]*Event, 0, len(c.status))
+	for name, s := range c.status {
+		events = append(events, c.setStatus(name, s.Optional, StateClosed, nil))
+	}
+	c.mu.Unlock()
+	c.emit(events...)
+	return errs.Err()
+}
+
+// closeLevel closes the named connections in independent goroutines,
+// giving up on those that are still closing once ctx is done
+func (c *Connections) closeLevel(ctx context.Context, resources map[string]io.Closer, names []string) error {
+	wg := sync.WaitGroup{}
+	errs := &errorList{}
+	for _, name := range names {
+		conn, ok := resources[name]
+		if !ok {
+			continue
+		}
+		closer := c.resourceCloser(ctx, name, conn)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			closed := make(chan error, 1)
+			go func() {
+				closed <- closer.Close()
+			}()
+			select {
+			case err := <-closed:
+				errs.Record(err)
+			case <-ctx.Done():
+				errs.Record(c.closeTimeout(ctx, name))
+			}
+		}()
+	}
+	wg.Wait()
+	return errs.Err()
+}
+
+// closeTimeout is the error for a resource that did not close before ctx was done
+func (c *Connections) closeTimeout(ctx context.Context, name string) error {
+	return &ResourceError{Resource: name, Err: fmt.Errorf("Closing %s did not finish: %w", name, context.Cause(ctx))}
+}
+
+// resourceCloser wraps a connection so that closing it emits a Closed event
+// and failures are reported as a *ResourceError. Connections with a
+// CloseContext(ctx) method, such as producers flushing messages, are closed with it.
+func (c *Connections) resourceCloser(ctx context.Context, name string, conn io.Closer) io.Closer {
+	return closerFunc(func() error {
+		start := time.Now()
+		var err error
+		if closer, ok := conn.(interface {
+			CloseContext(ctx context.Context) error
+		}); ok {
+			err = closer.CloseContext(ctx)
+		} else {
+			err = conn.Close()
+		}
+		if c.obs != nil {
+			c.obs.emit(Event{Type: Closed, Resource: name, Duration: time.Since(start), Err: err})
+		}
+		if err != nil {
+			return &ResourceError{Resource: name, Err: err}
+		}
+		return nil
+	})
+}
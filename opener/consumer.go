@@ -0,0 +1,86 @@
+package opener
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/explodes/ezconfig"
+	"github.com/explodes/ezconfig/backoff"
+	"github.com/explodes/ezconfig/consumer"
+	"github.com/explodes/ezconfig/consumer/registry"
+)
+
+const (
+	// ConsumerName is the name of the consumer resource created by WithConsumer
+	ConsumerName = "consumer"
+)
+
+// InitConsumer establishes a connection to a Consumer with the given strategy
+func InitConsumer(conf *ezconfig.ConsumerConfig, attempts int, wait backoff.Strategy) (consumer.Consumer, error) {
+	return InitConsumerContext(context.Background(), conf, attempts, wait)
+}
+
+// InitConsumerContext establishes a connection to a Consumer with the given strategy.
+// Retrying stops as soon as ctx is done.
+func InitConsumerContext(ctx context.Context, conf *ezconfig.ConsumerConfig, attempts int, wait backoff.Strategy) (consumer.Consumer, error) {
+	resource, err := ConsumerResource(consumerConfigLogger(conf, defaultLogger()))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := connectWithRetries(ctx, &resource, newConnections(), retryPolicy{attempts: attempts, strategy: wait}, &observer{log: defaultLogger()})
+	if err != nil {
+		return nil, err
+	}
+	return conn.(consumer.Consumer), nil
+}
+
+// ConsumerResource validates consumer configuration and builds a Resource that connects to it.
+// Consumers implementing consumer.Checker are checked with it.
+func ConsumerResource(conf *ezconfig.ConsumerConfig) (Resource, error) {
+	// determine type
+	factory, ok := registry.Get(conf.Settings.Type)
+	if !ok {
+		return Resource{}, fmt.Errorf("Invalid consumer type %s (was the consumer type imported?)", conf.Settings.Type)
+	}
+	// validate
+	if err := factory.Validate(conf); err != nil {
+		return Resource{}, err
+	}
+	return Resource{
+		Name:    ConsumerName,
+		Address: consumerAddress(conf),
+		Config:  conf,
+		Connect: connectConsumer(factory.Init),
+		Check:   checkConsumer,
+		Retry:   &conf.Settings.Retry,
+	}, nil
+}
+
+// connectConsumer builds a ConnectFunc that creates a consumer with init,
+// giving up once ctx is done
+func connectConsumer(init registry.InitFunc) ConnectFunc {
+	return func(ctx context.Context, config interface{}, deps *Connections) (io.Closer, error) {
+		return initContext(ctx, func() (consumer.Consumer, error) {
+			return init(config.(*ezconfig.ConsumerConfig))
+		})
+	}
+}
+
+// checkConsumer checks the consumer if it is a consumer.Checker
+func checkConsumer(ctx context.Context, conn io.Closer) error {
+	if checker, ok := conn.(consumer.Checker); ok {
+		return checker.Check(ctx)
+	}
+	return nil
+}
+
+// consumerAddress lists the consumer hosts for logging
+func consumerAddress(conf *ezconfig.ConsumerConfig) string {
+	addresses := make([]string, len(conf.Hosts))
+	for i := range conf.Hosts {
+		addresses[i] = conf.Hosts[i].Address()
+	}
+	return strings.Join(addresses, ",")
+}
@@ -0,0 +1,121 @@
+package opener
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+
+	"github.com/explodes/ezconfig/consumer"
+	"github.com/explodes/ezconfig/producer"
+)
+
+// ErrNotConnected is wrapped in the *ResourceError returned when a resource is used
+// while it is not connected, such as an optional resource that is degraded
+var ErrNotConnected = errors.New("Resource is not connected")
+
+// setFields sets Publisher, Producer and Consumer to connections that use the current
+// producer and consumer, so that they never change once Connect returns
+func (c *Connections) setFields(resources []Resource) {
+	for i := range resources {
+		switch resources[i].Name {
+		case ProducerName:
+			c.publisher = &currentPublisher{c: c}
+			c.Publisher = c.publisher
+			c.Producer = producer.ToProducer(c.Publisher, c.logger())
+		case ConsumerName:
+			c.Consumer = &currentConsumer{c: c}
+		}
+	}
+}
+
+// currentPublisher is a Publisher using the current producer connection,
+// connecting it first in lazy mode
+type currentPublisher struct {
+	c *Connections
+
+	// handler is given to every new producer connection, see apply
+	handler atomic.Pointer[producer.DeliveryHandler]
+}
+
+// get returns the current producer connection
+func (p *currentPublisher) get(ctx context.Context) (producer.Publisher, error) {
+	return GetContext[producer.Publisher](ctx, p.c, ProducerName)
+}
+
+func (p *currentPublisher) Publish(ctx context.Context, msg *producer.Message) error {
+	publisher, err := p.get(ctx)
+	if err != nil {
+		return err
+	}
+	return publisher.Publish(ctx, msg)
+}
+
+func (p *currentPublisher) Deliver(ctx context.Context, msg *producer.Message) (producer.Delivery, error) {
+	publisher, err := p.get(ctx)
+	if err != nil {
+		return producer.Delivery{Message: msg, Err: err}, err
+	}
+	return producer.Deliver(ctx, publisher, msg)
+}
+
+// OnDelivery sets the handler of the current producer connection,
+// and of those that replace it
+func (p *currentPublisher) OnDelivery(handler producer.DeliveryHandler) {
+	p.handler.Store(&handler)
+	if conn, ok := p.c.Get(ProducerName); ok {
+		p.apply(conn)
+	}
+}
+
+// apply sets the OnDelivery handler, if there is one, on a producer connection
+func (p *currentPublisher) apply(conn io.Closer) {
+	handler := p.handler.Load()
+	if notifier, ok := conn.(producer.Notifier); ok && handler != nil {
+		notifier.OnDelivery(*handler)
+	}
+}
+
+func (p *currentPublisher) Check(ctx context.Context) error {
+	return p.c.Check(ctx, ProducerName)
+}
+
+// Close closes the current producer connection, if there is one
+func (p *currentPublisher) Close() error {
+	if conn, ok := p.c.Get(ProducerName); ok {
+		return conn.Close()
+	}
+	return nil
+}
+
+// currentConsumer is a Consumer using the current consumer connection,
+// connecting it first in lazy mode
+type currentConsumer struct {
+	c *Connections
+}
+
+// Subscribe subscribes with the current consumer connection. When it is replaced
+// while subscribed, the subscription continues with the new connection.
+func (s *currentConsumer) Subscribe(ctx context.Context, topics []string, handler consumer.Handler) error {
+	for {
+		subscribed, err := GetContext[consumer.Consumer](ctx, s.c, ConsumerName)
+		if err != nil {
+			return err
+		}
+		err = subscribed.Subscribe(ctx, topics, handler)
+		if ctx.Err() != nil || s.c.ctx.Err() != nil {
+			return err
+		}
+		if current, ok := s.c.Get(ConsumerName); !ok || current == io.Closer(subscribed) {
+			return err
+		}
+	}
+}
+
+// Close closes the current consumer connection, if there is one
+func (s *currentConsumer) Close() error {
+	if conn, ok := s.c.Get(ConsumerName); ok {
+		return conn.Close()
+	}
+	return nil
+}
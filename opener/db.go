@@ -1,57 +1,83 @@
 package opener
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
-	"time"
+	"io"
 
 	"github.com/explodes/ezconfig"
 	"github.com/explodes/ezconfig/backoff"
 	"github.com/explodes/ezconfig/db/registry"
 )
 
+const (
+	// DatabaseName is the name of the database resource created by WithDatabase
+	DatabaseName = "database"
+)
+
 // InitDb establishes a connection to a database with the given strategy
 func InitDb(conf *ezconfig.DbConfig, attempts int, wait backoff.Strategy) (*sql.DB, error) {
+	return InitDbContext(context.Background(), conf, attempts, wait)
+}
+
+// InitDbContext establishes a connection to a database with the given strategy.
+// Retrying stops as soon as ctx is done.
+func InitDbContext(ctx context.Context, conf *ezconfig.DbConfig, attempts int, wait backoff.Strategy) (*sql.DB, error) {
+	resource, err := DatabaseResource(dbConfigLogger(conf, defaultLogger()))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := connectWithRetries(ctx, &resource, newConnections(), retryPolicy{attempts: attempts, strategy: wait}, &observer{log: defaultLogger()})
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*sql.DB), nil
+}
+
+// DatabaseResource validates database configuration and builds a Resource that connects to it.
+// A "Ping" is sent to the database to test each connection.
+func DatabaseResource(conf *ezconfig.DbConfig) (Resource, error) {
 	// determine type
 	factory, ok := registry.Get(conf.Database.Type)
 	if !ok {
-		return nil, fmt.Errorf("Invalid database type %s (was the database type imported?)", conf.Database.Type)
+		return Resource{}, fmt.Errorf("Invalid database type %s (was the database type imported?)", conf.Database.Type)
 	}
 	// validate
+	if err := factory.ValidateParams(conf); err != nil {
+		return Resource{}, err
+	}
 	if err := factory.Validate(conf); err != nil {
-		return nil, err
+		return Resource{}, err
 	}
-	return initDbWithRetries(conf, factory.Init, attempts, wait)
+	return Resource{
+		Name:    DatabaseName,
+		Address: conf.Database.Address(),
+		Config:  conf,
+		Connect: connectDb(factory.Init),
+		Check:   checkDb,
+		Retry:   &conf.Database.Retry,
+	}, nil
 }
 
-// initDbWithRetries attempts to connect to a database a given number of times.
-// If attempts is less than or equal to one, only one attempt will be made.
-// A "Ping" is sent to the database to test the connection.
-func initDbWithRetries(conf *ezconfig.DbConfig, init registry.InitFunc, attempts int, wait backoff.Strategy) (*sql.DB, error) {
-	if attempts <= 0 {
-		attempts = 1
-	}
-	var db *sql.DB
-	var err error
-	for attempt := 0; attempt < attempts; attempt++ {
-		db, err = init(conf)
+// connectDb builds a ConnectFunc that opens a database with init and pings it
+func connectDb(init registry.InitFunc) ConnectFunc {
+	return func(ctx context.Context, config interface{}, deps *Connections) (io.Closer, error) {
+		conf := config.(*ezconfig.DbConfig)
+		db, err := init(conf)
 		if err != nil {
-			log.Printf("Unable to connect to database (attempt %d of %d): %v", attempt+1, attempts, err)
-			time.Sleep(wait.Duration(attempt))
-			continue
+			return nil, err
 		}
-		if err = db.Ping(); err != nil {
-			log.Printf("Unable to connect to database (attempt %d of %d) (%v)", attempt+1, attempts, err)
-			time.Sleep(wait.Duration(attempt))
-			continue
+		if err := db.PingContext(ctx); err != nil {
+			db.Close()
+			return nil, err
 		}
-		break
+		db.SetMaxOpenConns(conf.Database.MaxConnections)
+		return db, nil
 	}
-	if err != nil {
-		log.Printf("Unable to connect to database after %d tries", attempts)
-		return nil, err
-	}
-	db.SetMaxOpenConns(conf.Database.MaxConnections)
-	return db, nil
+}
+
+// checkDb pings the database
+func checkDb(ctx context.Context, conn io.Closer) error {
+	return conn.(*sql.DB).PingContext(ctx)
 }
@@ -0,0 +1,85 @@
+package opener
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// ResourceError is an error connecting to or closing a resource.
+// Use errors.As to find out which resource failed, or ResourceErrors
+// to list every resource that failed.
+type ResourceError struct {
+	Resource string
+	Err      error
+}
+
+// Error describes the failure, prefixed with the resource name
+func (e *ResourceError) Error() string {
+	return e.Resource + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *ResourceError) Unwrap() error {
+	return e.Err
+}
+
+// ResourceErrors lists every *ResourceError in err's tree
+func ResourceErrors(err error) []*ResourceError {
+	var result []*ResourceError
+	var walk func(err error)
+	walk = func(err error) {
+		if err == nil {
+			return
+		}
+		if resourceErr, ok := err.(*ResourceError); ok {
+			result = append(result, resourceErr)
+			return
+		}
+		switch wrapped := err.(type) {
+		case interface{ Unwrap() []error }:
+			for _, err := range wrapped.Unwrap() {
+				walk(err)
+			}
+		case interface{ Unwrap() error }:
+			walk(wrapped.Unwrap())
+		}
+	}
+	walk(err)
+	return result
+}
+
+// errorList is a construct that records every error it receives via Record
+type errorList struct {
+	sync.Mutex
+	errs []error
+}
+
+// Record saves the error, ignoring nil errors
+func (e *errorList) Record(err error) {
+	if err == nil {
+		return
+	}
+	e.Lock()
+	defer e.Unlock()
+	e.errs = append(e.errs, err)
+}
+
+// Err joins the recorded errors, ordered by resource name for stable messages
+func (e *errorList) Err() error {
+	e.Lock()
+	defer e.Unlock()
+	sort.SliceStable(e.errs, func(i, j int) bool {
+		return resourceName(e.errs[i]) < resourceName(e.errs[j])
+	})
+	return errors.Join(e.errs...)
+}
+
+// resourceName is the name of the resource an error belongs to, if any
+func resourceName(err error) string {
+	var resourceErr *ResourceError
+	if errors.As(err, &resourceErr) {
+		return resourceErr.Resource
+	}
+	return ""
+}
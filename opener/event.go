@@ -0,0 +1,103 @@
+package opener
+
+import (
+	"log/slog"
+	"time"
+)
+
+// EventType identifies a step in the life of a connection
+type EventType int
+
+const (
+	// AttemptStarted is sent before each connection attempt
+	AttemptStarted EventType = iota
+	// AttemptFailed is sent when a connection attempt fails
+	AttemptFailed
+	// Connected is sent when a connection attempt succeeds
+	Connected
+	// GaveUp is sent when every attempt failed or the context was done
+	GaveUp
+	// Closed is sent when a connection has been closed
+	Closed
+	// StateChanged is sent when a resource changes State
+	StateChanged
+)
+
+// String returns the name of the event type
+func (t EventType) String() string {
+	switch t {
+	case AttemptStarted:
+		return "attempt_started"
+	case AttemptFailed:
+		return "attempt_failed"
+	case Connected:
+		return "connected"
+	case GaveUp:
+		return "gave_up"
+	case Closed:
+		return "closed"
+	case StateChanged:
+		return "state_changed"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes a step in the life of a resource's connection
+type Event struct {
+	Type     EventType
+	Resource string
+	Time     time.Time
+
+	// Attempt is the 1-based attempt number and MaxAttempts the number of
+	// attempts that will be made. Both are zero for Closed events.
+	Attempt     int
+	MaxAttempts int
+
+	// Duration is how long the attempt, or closing, took
+	Duration time.Duration
+
+	// Elapsed is the time since the first attempt to connect the resource started
+	Elapsed time.Duration
+
+	// Wait is the time until the next attempt for AttemptFailed events
+	Wait time.Duration
+
+	// Previous and State are the states before and after StateChanged events
+	Previous State
+	State    State
+
+	// Err is the error for AttemptFailed, GaveUp and Closed events, and
+	// for StateChanged events caused by an error
+	Err error
+}
+
+// Hook receives connection events. Hooks are called synchronously from the
+// goroutine connecting, checking or closing the resource, and must be safe for concurrent use.
+type Hook interface {
+	OnEvent(event Event)
+}
+
+// HookFunc adapts a function to a Hook
+type HookFunc func(event Event)
+
+// OnEvent calls f
+func (f HookFunc) OnEvent(event Event) {
+	f(event)
+}
+
+// observer reports connection progress to a logger and hooks
+type observer struct {
+	log   *slog.Logger
+	hooks []Hook
+}
+
+// emit sends an event to every hook
+func (o *observer) emit(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	for _, hook := range o.hooks {
+		hook.OnEvent(event)
+	}
+}
@@ -0,0 +1,72 @@
+package opener
+
+import (
+	"fmt"
+	"strings"
+)
+
+// levels groups resources by their depth in the dependency graph. Resources in
+// level 0 have no dependencies, and every other resource only depends on
+// resources in earlier levels. Missing dependencies and cycles are errors.
+func levels(resources []Resource) ([][]string, error) {
+	byName := make(map[string]*Resource, len(resources))
+	for i := range resources {
+		byName[resources[i].Name] = &resources[i]
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(resources))
+	depth := make(map[string]int, len(resources))
+	var path []string
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			start := 0
+			for path[start] != name {
+				start++
+			}
+			cycle := append(append([]string(nil), path[start:]...), name)
+			return fmt.Errorf("Dependency cycle: %s", strings.Join(cycle, " -> "))
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range byName[name].DependsOn {
+			if _, ok := byName[dep]; !ok {
+				return fmt.Errorf("Resource %s depends on unknown resource %s", name, dep)
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+			if depth[dep]+1 > depth[name] {
+				depth[name] = depth[dep] + 1
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+
+	var result [][]string
+	for i := range resources {
+		name := resources[i].Name
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	for i := range resources {
+		name := resources[i].Name
+		for len(result) <= depth[name] {
+			result = append(result, nil)
+		}
+		result[depth[name]] = append(result[depth[name]], name)
+	}
+	return result, nil
+}
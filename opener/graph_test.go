@@ -0,0 +1,45 @@
+package opener
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLevels(t *testing.T) {
+	resources := []Resource{
+		{Name: "outbox", DependsOn: []string{"database", "producer"}},
+		{Name: "database"},
+		{Name: "producer"},
+		{Name: "relay", DependsOn: []string{"outbox"}},
+	}
+	result, err := levels(resources)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := [][]string{{"database", "producer"}, {"outbox"}, {"relay"}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Unexpected levels %v", result)
+	}
+}
+
+func TestLevels_cycle(t *testing.T) {
+	resources := []Resource{
+		{Name: "a", DependsOn: []string{"b"}},
+		{Name: "b", DependsOn: []string{"c"}},
+		{Name: "c", DependsOn: []string{"a"}},
+	}
+	_, err := levels(resources)
+	if err == nil || !strings.Contains(err.Error(), "a -> b -> c -> a") {
+		t.Fatalf("Expected a cycle error, got %v", err)
+	}
+}
+
+func TestLevels_unknown(t *testing.T) {
+	resources := []Resource{
+		{Name: "a", DependsOn: []string{"b"}},
+	}
+	if _, err := levels(resources); err == nil {
+		t.Fatal("Expected an error for an unknown dependency")
+	}
+}
@@ -0,0 +1,83 @@
+package opener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// lazyResource is a resource connected on first use
+type lazyResource struct {
+	// mu makes concurrent first callers wait for a single connection
+	mu          sync.Mutex
+	resource    *Resource
+	supervision supervision
+}
+
+// GetContext returns the connection to the named resource. In lazy mode a resource
+// that is not connected yet is connected first, after its dependencies, with its
+// retry policy. Errors are reported as *ResourceError values, as they are by Connect.
+// Retrying stops as soon as ctx is done.
+func (c *Connections) GetContext(ctx context.Context, name string) (io.Closer, error) {
+	if c.parent != nil {
+		return c.parent.GetContext(ctx, name)
+	}
+	if conn, ok := c.Get(name); ok {
+		return conn, nil
+	}
+	lazy, ok := c.lazy[name]
+	if !ok {
+		return nil, &ResourceError{Resource: name, Err: ErrNotConnected}
+	}
+	return c.connectLazy(ctx, lazy)
+}
+
+// connectLazy connects a lazy resource and its dependencies. A resource that fails
+// to connect is tried again on next use.
+func (c *Connections) connectLazy(ctx context.Context, lazy *lazyResource) (io.Closer, error) {
+	lazy.mu.Lock()
+	defer lazy.mu.Unlock()
+
+	resource := lazy.resource
+	// another caller may have connected it while we waited
+	if conn, ok := c.Get(resource.Name); ok {
+		return conn, nil
+	}
+	if c.ctx.Err() != nil {
+		return nil, &ResourceError{Resource: resource.Name, Err: errClosed}
+	}
+
+	for _, dep := range resource.DependsOn {
+		if _, err := c.GetContext(ctx, dep); err != nil {
+			return nil, errors.Join(err, &ResourceError{Resource: resource.Name, Err: fmt.Errorf("dependency %s failed", dep)})
+		}
+	}
+	deps, _ := c.dependencies(resource.DependsOn)
+
+	conn, err := lazy.supervision.connect(ctx, deps)
+	if err != nil {
+		c.transition(resource, StateDegraded, err)
+		return nil, &ResourceError{Resource: resource.Name, Err: err}
+	}
+
+	c.mu.Lock()
+	if c.ctx.Err() != nil {
+		c.mu.Unlock()
+		conn.Close()
+		return nil, &ResourceError{Resource: resource.Name, Err: errClosed}
+	}
+	c.add(resource.Name, conn)
+	event := c.setStatus(resource.Name, resource.Optional, StateConnected, nil)
+	// started while c.mu is held, so that Close cannot be waiting for it yet
+	if lazy.supervision.check > 0 && resource.Check != nil {
+		c.startWatch(resource, lazy.supervision)
+	}
+	c.mu.Unlock()
+	c.emit(event)
+	return conn, nil
+}
+
+// errClosed is returned when a lazy resource is used after the connections were closed
+var errClosed = errors.New("Connections are closed")
@@ -0,0 +1,61 @@
+package opener
+
+import (
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/explodes/ezconfig"
+)
+
+// logger is the logger set with SetLogger
+var logger atomic.Pointer[slog.Logger]
+
+// SetLogger sets the logger used by InitDb, InitProducer and by Openers without
+// a logger of their own. Passing nil restores the default, slog.Default().
+// Databases, producers and consumers log with it too, unless their configuration
+// has a Logger.
+//
+// To silence connection logging, such as in tests:
+//
+//	opener.SetLogger(slog.New(slog.DiscardHandler))
+func SetLogger(l *slog.Logger) {
+	logger.Store(l)
+}
+
+// defaultLogger returns the logger set with SetLogger, or slog.Default()
+func defaultLogger() *slog.Logger {
+	if l := logger.Load(); l != nil {
+		return l
+	}
+	return slog.Default()
+}
+
+// dbConfigLogger returns conf, or a copy of it that logs with logger if it has no logger of its own
+func dbConfigLogger(conf *ezconfig.DbConfig, logger *slog.Logger) *ezconfig.DbConfig {
+	if conf.Logger != nil {
+		return conf
+	}
+	copied := *conf
+	copied.Logger = logger
+	return &copied
+}
+
+// producerConfigLogger returns conf, or a copy of it that logs with logger if it has no logger of its own
+func producerConfigLogger(conf *ezconfig.ProducerConfig, logger *slog.Logger) *ezconfig.ProducerConfig {
+	if conf.Logger != nil {
+		return conf
+	}
+	copied := *conf
+	copied.Logger = logger
+	return &copied
+}
+
+// consumerConfigLogger returns conf, or a copy of it that logs with logger if it has no logger of its own
+func consumerConfigLogger(conf *ezconfig.ConsumerConfig, logger *slog.Logger) *ezconfig.ConsumerConfig {
+	if conf.Logger != nil {
+		return conf
+	}
+	copied := *conf
+	copied.Logger = logger
+	return &copied
+}
@@ -0,0 +1,56 @@
+package opener
+
+import (
+	"github.com/explodes/ezconfig/metrics"
+)
+
+// attemptBuckets are histogram buckets for the number of attempts needed to connect
+var attemptBuckets = []float64{1, 2, 3, 5, 10, 20, 50}
+
+// MetricsHook builds a Hook that records connection metrics in the registry:
+//
+//	ezconfig_connect_attempts_total          counter of connection attempts
+//	ezconfig_connect_failures_total          counter of failed attempts
+//	ezconfig_connect_gave_up_total           counter of resources that could not be connected
+//	ezconfig_connect_attempt_duration_seconds histogram of the time taken by each attempt
+//	ezconfig_connect_duration_seconds        histogram of the time taken to connect, including retries
+//	ezconfig_connect_attempts                histogram of the attempts needed to connect
+//	ezconfig_close_total                     counter of closed connections
+//	ezconfig_close_errors_total              counter of connections that failed to close
+//	ezconfig_state_changes_total             counter of state changes, also labeled by the new state
+//
+// Every metric is labeled by resource.
+func MetricsHook(registry *metrics.Registry) Hook {
+	attempts := registry.Counter("ezconfig_connect_attempts_total", "Connection attempts made.", "resource")
+	failures := registry.Counter("ezconfig_connect_failures_total", "Connection attempts that failed.", "resource")
+	gaveUp := registry.Counter("ezconfig_connect_gave_up_total", "Resources that could not be connected.", "resource")
+	attemptDuration := registry.Histogram("ezconfig_connect_attempt_duration_seconds", "Time taken by each connection attempt.", metrics.DefBuckets, "resource")
+	connectDuration := registry.Histogram("ezconfig_connect_duration_seconds", "Time taken to connect, including retries.", metrics.DefBuckets, "resource")
+	connectAttempts := registry.Histogram("ezconfig_connect_attempts", "Attempts needed to connect.", attemptBuckets, "resource")
+	closes := registry.Counter("ezconfig_close_total", "Connections closed.", "resource")
+	closeErrors := registry.Counter("ezconfig_close_errors_total", "Connections that failed to close.", "resource")
+	stateChanges := registry.Counter("ezconfig_state_changes_total", "Resource state changes.", "resource", "state")
+
+	return HookFunc(func(event Event) {
+		switch event.Type {
+		case AttemptStarted:
+			attempts.Inc(event.Resource)
+		case AttemptFailed:
+			failures.Inc(event.Resource)
+			attemptDuration.Observe(event.Duration.Seconds(), event.Resource)
+		case Connected:
+			attemptDuration.Observe(event.Duration.Seconds(), event.Resource)
+			connectDuration.Observe(event.Elapsed.Seconds(), event.Resource)
+			connectAttempts.Observe(float64(event.Attempt), event.Resource)
+		case GaveUp:
+			gaveUp.Inc(event.Resource)
+		case Closed:
+			closes.Inc(event.Resource)
+			if event.Err != nil {
+				closeErrors.Inc(event.Resource)
+			}
+		case StateChanged:
+			stateChanges.Inc(event.Resource, event.State.String())
+		}
+	})
+}
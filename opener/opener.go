@@ -1,14 +1,19 @@
 package opener
 
 import (
+	"context"
 	"database/sql"
-	"sync"
-
+	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/explodes/ezconfig"
 	"github.com/explodes/ezconfig/backoff"
-	"github.com/explodes/ezconfig/producer"
+	"github.com/explodes/ezconfig/metrics"
+	"github.com/explodes/ezconfig/migrate"
 )
 
 // Opener is helper designed to make connecting to multiple services easy.
@@ -19,34 +24,97 @@ import (
 //   		WithRetry(connectionRetries, backoff.Constant(1*time.Second)).
 //   		WithDatabase(&config.DbConfig).
 //   		WithProducer(&config.ProducerConfig).
+//   		WithConsumer(&config.ConsumerConfig).
+//   		WithResource(cacheResource).
 //   		Connect()
 type Opener struct {
 	file           string
 	dbConfig       *ezconfig.DbConfig
 	producerConfig *ezconfig.ProducerConfig
+	consumerConfig *ezconfig.ConsumerConfig
+	resources      []Resource
+	migrations     fs.FS
 	retries        int
 	backoff        backoff.Strategy
+	retryOverrides map[string]*retryPolicy
+	logger         *slog.Logger
+	hooks          []Hook
+	registry       *metrics.Registry
+	optional       map[string]bool
+	reconnect      time.Duration
+	check          time.Duration
+	lazy           bool
 }
 
-// Connections is the result of connecting to multiple sources
-type Connections struct {
-	DB       *sql.DB
-	Producer producer.Producer
-}
+// DefaultReconnectInterval is how often optional resources that could not be
+// connected are retried in the background, see WithReconnectInterval
+const DefaultReconnectInterval = 5 * time.Second
 
 // New creates a New opener with no retry attempts or backoff strategy
 func New() *Opener {
-	return &Opener{}
+	return &Opener{
+		retryOverrides: make(map[string]*retryPolicy),
+		optional:       make(map[string]bool),
+		reconnect:      DefaultReconnectInterval,
+	}
 }
 
 // WithRetry sets the number of attempts to make to each source and the
-// backoff strategy to utilize when re-attempting
+// backoff strategy to utilize when re-attempting.
+// It applies to sources with no retry settings of their own.
 func (co *Opener) WithRetry(retries int, strategy backoff.Strategy) *Opener {
 	co.retries = retries
 	co.backoff = strategy
 	return co
 }
 
+// WithLogger sets the logger used while connecting, overriding SetLogger
+func (co *Opener) WithLogger(logger *slog.Logger) *Opener {
+	co.logger = logger
+	return co
+}
+
+// WithHook adds a hook that receives connection events, see MetricsHook
+func (co *Opener) WithHook(hook Hook) *Opener {
+	co.hooks = append(co.hooks, hook)
+	return co
+}
+
+// WithMetrics records connection metrics in the registry, see MetricsHook,
+// and instruments the producer, see producer.Instrument
+func (co *Opener) WithMetrics(registry *metrics.Registry) *Opener {
+	co.registry = registry
+	return co.WithHook(MetricsHook(registry))
+}
+
+// observer builds the observer that reports connection progress
+func (co *Opener) observer() *observer {
+	logger := co.logger
+	if logger == nil {
+		logger = defaultLogger()
+	}
+	return &observer{log: logger, hooks: co.hooks}
+}
+
+// WithResourceRetry sets the number of attempts to make to the named resource and the
+// backoff strategy to utilize when re-attempting, overriding its configuration and WithRetry
+func (co *Opener) WithResourceRetry(name string, retries int, strategy backoff.Strategy) *Opener {
+	co.retryOverrides[name] = &retryPolicy{attempts: retries, strategy: strategy}
+	return co
+}
+
+// WithDatabaseRetry sets the number of attempts to make to the database and the
+// backoff strategy to utilize when re-attempting, overriding [database.retry] and WithRetry
+func (co *Opener) WithDatabaseRetry(retries int, strategy backoff.Strategy) *Opener {
+	return co.WithResourceRetry(DatabaseName, retries, strategy)
+}
+
+// WithProducerRetry sets the number of attempts to make to the producer and the
+// backoff strategy to utilize when re-attempting, overriding [producer.retry] and WithRetry
+func (co *Opener) WithProducerRetry(retries int, strategy backoff.Strategy) *Opener {
+	return co.WithResourceRetry(ProducerName, retries, strategy)
+}
+
 // WithDatabase specifies that an attempt should be made to connect to a database
 // and which settings to use to do so
 func (co *Opener) WithDatabase(config *ezconfig.DbConfig) *Opener {
@@ -54,6 +122,13 @@ func (co *Opener) WithDatabase(config *ezconfig.DbConfig) *Opener {
 	return co
 }
 
+// WithMigrations specifies that the database should be migrated with the migration
+// files in the given filesystem before Connect returns. See migrate.Load for the file layout.
+func (co *Opener) WithMigrations(migrations fs.FS) *Opener {
+	co.migrations = migrations
+	return co
+}
+
 // WithProducer specifies that an attempt should be made to connect to a producer
 // and which settings to use to do so
 func (co *Opener) WithProducer(config *ezconfig.ProducerConfig) *Opener {
@@ -61,77 +136,268 @@ func (co *Opener) WithProducer(config *ezconfig.ProducerConfig) *Opener {
 	return co
 }
 
+// WithConsumer specifies that an attempt should be made to connect to a consumer
+// and which settings to use to do so. The consumer depends on the database and
+// producer, if any, so that it is closed first and handlers may use them until
+// their subscriptions stop.
+func (co *Opener) WithConsumer(config *ezconfig.ConsumerConfig) *Opener {
+	co.consumerConfig = config
+	return co
+}
+
+// WithResource specifies that an attempt should be made to connect to a resource.
+// Resources are connected concurrently with the database and producer.
+func (co *Opener) WithResource(resource Resource) *Opener {
+	co.resources = append(co.resources, resource)
+	return co
+}
+
+// WithOptional marks the named resources as optional, such as DatabaseName or
+// ProducerName. Resources may also be marked optional with Resource.Optional.
+// While an optional producer or consumer is not connected, Connections.Publisher,
+// Producer and Consumer fail with ErrNotConnected.
+func (co *Opener) WithOptional(names ...string) *Opener {
+	for _, name := range names {
+		co.optional[name] = true
+	}
+	return co
+}
+
+// WithReconnectInterval sets how often optional resources that could not be
+// connected are retried in the background, each time with their retry policy
+func (co *Opener) WithReconnectInterval(interval time.Duration) *Opener {
+	co.reconnect = interval
+	return co
+}
+
+// WithSupervisor health checks every connected resource that has a Check function
+// each interval once it is connected. A resource that fails its check is
+// re-initialized with its retry policy, and the new connection replaces the old one,
+// see Handle. Each check must complete within the interval.
+//
+// Resources that depend on a re-initialized resource keep the connection they were given,
+// which is closed once it is replaced. To follow reconnects, they can keep a Handle
+// on the dependencies passed to their ConnectFunc.
+func (co *Opener) WithSupervisor(interval time.Duration) *Opener {
+	co.check = interval
+	return co
+}
+
+// WithLazy makes Connect validate the configuration and return without connecting.
+// Each resource is connected on first use through GetContext, Get or a Handle,
+// with its retry policy, and is not connected again by concurrent callers.
+// DB is not set in lazy mode, while Publisher, Producer and Consumer connect on first use.
+func (co *Opener) WithLazy() *Opener {
+	co.lazy = true
+	return co
+}
+
 // Connect connects to the services that are set.
-// In the event of error, anything successfully connected to is closed, and
-// the first error received is returned.
+// In the event of error, anything successfully connected to is closed before
+// Connect returns, and every error received is returned, joined. Each is a *ResourceError
+// naming the resource that failed.
+//
+// Optional resources that cannot be connected, or whose dependencies cannot be,
+// do not fail Connect. They are reported as StateDegraded by Connections.Status
+// and reconnected in the background until the connections are closed.
+// Invalid configuration fails Connect whether a resource is optional or not.
 func (co *Opener) Connect() (*Connections, error) {
+	return co.ConnectContext(context.Background())
+}
+
+// ConnectContext is Connect, but stops retrying as soon as ctx is done.
+// Resources are connected concurrently, except that each waits for its dependencies.
+func (co *Opener) ConnectContext(ctx context.Context) (*Connections, error) {
+	resources, err := co.buildResources()
+	if err != nil {
+		return nil, err
+	}
+	order, err := levels(resources)
+	if err != nil {
+		return nil, err
+	}
 
 	wg := sync.WaitGroup{}
-	result := &Connections{}
-	errs := &firstError{}
+	obs := co.observer()
+	result := newConnections()
+	result.levels = order
+	result.obs = obs
+	result.setFields(resources)
+	result.ctx, result.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	errs := &errorList{}
+	initial := StateConnecting
+	if co.lazy {
+		initial = StateIdle
+	}
+	for i := range resources {
+		result.status[resources[i].Name] = &ResourceStatus{State: initial, Optional: resources[i].Optional, Since: time.Now()}
+		if resources[i].Check != nil {
+			result.checks[resources[i].Name] = resources[i].Check
+		}
+	}
 
-	if co.dbConfig != nil {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			errs.Record(co.connectDb(result))
-		}()
+	// done is closed when a resource has finished connecting, successfully or not
+	done := make(map[string]chan struct{}, len(resources))
+	for i := range resources {
+		done[resources[i].Name] = make(chan struct{})
 	}
 
-	if co.producerConfig != nil {
+	for i := range resources {
+		resource := &resources[i]
+		supervision := supervision{
+			connect: func(ctx context.Context, deps *Connections) (io.Closer, error) {
+				return co.connectResource(ctx, resource, deps, obs)
+			},
+			reconnect: co.reconnect,
+			check:     co.check,
+		}
+		if co.lazy {
+			result.lazy[resource.Name] = &lazyResource{resource: resource, supervision: supervision}
+			continue
+		}
+		fail := func(err error) {
+			if !resource.Optional {
+				errs.Record(&ResourceError{Resource: resource.Name, Err: err})
+				return
+			}
+			obs.log.Warn("Unable to connect optional resource, continuing without it",
+				"service", resource.Name,
+				"error", err,
+				"address", resource.Address)
+			result.transition(resource, StateDegraded, err)
+			result.startWatch(resource, supervision)
+		}
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			errs.Record(co.connectBroker(result))
+			defer close(done[resource.Name])
+
+			for _, dep := range resource.DependsOn {
+				<-done[dep]
+				if _, ok := result.Get(dep); !ok {
+					fail(fmt.Errorf("dependency %s failed", dep))
+					return
+				}
+			}
+			deps, _ := result.dependencies(resource.DependsOn)
+
+			conn, err := supervision.connect(ctx, deps)
+			if err != nil {
+				fail(err)
+				return
+			}
+			result.connected(resource, conn)
+			if co.check > 0 && resource.Check != nil {
+				result.startWatch(resource, supervision)
+			}
 		}()
 	}
 
 	wg.Wait()
+	if conn, ok := result.Get(DatabaseName); ok {
+		result.DB, _ = conn.(*sql.DB)
+	}
 
-	if errs.err != nil {
-		// close any connections we may have made
-		// it is in a goroutine so that the unusuable
-		// results aren't blocking the downstream
-		go result.Close()
-		return nil, errs.err
+	if errs.Err() != nil {
+		// close any connections we may have made,
+		// reporting those that fail to close as well
+		errs.Record(result.Close())
+		return nil, errs.Err()
 	}
 	return result, nil
 }
 
-// connectDb connects to a database and saves the result in the given Connections
-func (co *Opener) connectDb(result *Connections) error {
-	database, err := InitDb(co.dbConfig, co.retries, co.backoff)
-	if err != nil {
-		return err
-	} else {
-		result.DB = database
-		return nil
+// buildResources validates and collects every resource to connect to
+func (co *Opener) buildResources() ([]Resource, error) {
+	var resources []Resource
+	errs := &errorList{}
+	logger := co.observer().log
+	if co.dbConfig != nil {
+		resource, err := DatabaseResource(dbConfigLogger(co.dbConfig, logger))
+		if err != nil {
+			errs.Record(&ResourceError{Resource: DatabaseName, Err: err})
+		}
+		resources = append(resources, resource)
+	}
+	if co.producerConfig != nil {
+		resource, err := ProducerResource(producerConfigLogger(co.producerConfig, logger))
+		if err != nil {
+			errs.Record(&ResourceError{Resource: ProducerName, Err: err})
+		}
+		if co.registry != nil {
+			resource.Connect = instrumentProducer(resource.Connect, co.registry)
+		}
+		resources = append(resources, resource)
+	}
+	if co.consumerConfig != nil {
+		resource, err := ConsumerResource(consumerConfigLogger(co.consumerConfig, logger))
+		if err != nil {
+			errs.Record(&ResourceError{Resource: ConsumerName, Err: err})
+		}
+		if co.dbConfig != nil {
+			resource.DependsOn = append(resource.DependsOn, DatabaseName)
+		}
+		if co.producerConfig != nil {
+			resource.DependsOn = append(resource.DependsOn, ProducerName)
+		}
+		resources = append(resources, resource)
+	}
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+	resources = append(resources, co.resources...)
+
+	names := make(map[string]bool)
+	for i := range resources {
+		if co.optional[resources[i].Name] {
+			resources[i].Optional = true
+		}
+		if err := resources[i].validate(); err != nil {
+			return nil, err
+		}
+		if names[resources[i].Name] {
+			return nil, fmt.Errorf("Resource %s specified twice", resources[i].Name)
+		}
+		names[resources[i].Name] = true
 	}
+	return resources, nil
 }
 
-// connectBroker connects to a producer and saves the result in the given Connections
-func (co *Opener) connectBroker(result *Connections) error {
-	prod, err := InitProducer(co.producerConfig, co.retries, co.backoff)
+// connectResource connects to a resource with its retry policy.
+// The database is migrated once it is connected.
+func (co *Opener) connectResource(ctx context.Context, resource *Resource, deps *Connections, obs *observer) (io.Closer, error) {
+	retry, err := co.resolveRetry(co.retryOverrides[resource.Name], resource.Retry)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := connectWithRetries(ctx, resource, deps, retry, obs)
 	if err != nil {
-		return err
-	} else {
-		result.Producer = prod
-		return nil
+		return nil, err
 	}
+	if resource.Name == DatabaseName && co.migrations != nil {
+		conf := resource.Config.(*ezconfig.DbConfig)
+		if err := migrate.UpContext(ctx, conn.(*sql.DB), conf.Database.Type, co.migrations); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
 }
 
-// Close closes all active connections (each in independent goroutines) and returns the
-// first error received
-func (c *Connections) Close() error {
-	return CloseAll(c.DB, c.Producer)
+// closerFunc adapts a function to io.Closer
+type closerFunc func() error
+
+// Close calls f
+func (f closerFunc) Close() error {
+	return f()
 }
 
-// CloseAll closes all io.Closers (each in independent goroutines) and returns the
-// first error received
+// CloseAll closes all io.Closers (each in independent goroutines) and returns
+// every error received, joined
 func CloseAll(closers ...io.Closer) error {
 	wg := sync.WaitGroup{}
 
-	errs := &firstError{}
+	errs := &errorList{}
 
 	closeAndRecordError := func(c io.Closer) {
 		if c == nil {
@@ -149,23 +415,5 @@ func CloseAll(closers ...io.Closer) error {
 	}
 
 	wg.Wait()
-	return errs.err
-}
-
-// firstError is a construct that records the first error it receives via Record
-type firstError struct {
-	sync.Mutex
-	err error
-}
-
-// Record saves the error if there has been no other error
-func (e *firstError) Record(err error) {
-	if err == nil {
-		return
-	}
-	e.Lock()
-	defer e.Unlock()
-	if e.err == nil {
-		e.err = err
-	}
+	return errs.Err()
 }
@@ -0,0 +1,1222 @@
+package opener
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/explodes/ezconfig"
+	"github.com/explodes/ezconfig/backoff"
+	"github.com/explodes/ezconfig/consumer"
+	"github.com/explodes/ezconfig/consumer/dummy"
+	"github.com/explodes/ezconfig/db/registry"
+	"github.com/explodes/ezconfig/metrics"
+	"github.com/explodes/ezconfig/producer"
+	producerregistry "github.com/explodes/ezconfig/producer/registry"
+)
+
+const (
+	// failingDbType is a database type that never connects
+	failingDbType = "opener_test_failing"
+
+	// testProducerType is a producer type that always connects
+	testProducerType = "opener_test_producer"
+
+	// legacyProducerType is a producer type registered with the original Producer interface
+	legacyProducerType = "opener_test_legacy_producer"
+
+	// warningProducerType is a producer type that logs a warning when it is validated
+	warningProducerType = "opener_test_warning_producer"
+
+	// slowProducerType is a producer type that connects once the release channel
+	// of slowProducer is closed
+	slowProducerType = "opener_test_slow_producer"
+)
+
+var errUnreachable = errors.New("database unreachable")
+
+// slowProducer holds the channels of the current slowProducerType test
+var slowProducer atomic.Pointer[slowInit]
+
+// slowInit lets slowProducerType connect once release is closed, sending its connection to connected
+type slowInit struct {
+	release   chan struct{}
+	connected chan *notifyingPublisher
+}
+
+func init() {
+	registry.Register(failingDbType, func(conf *ezconfig.DbConfig) (*sql.DB, error) {
+		return nil, errUnreachable
+	}, func(conf *ezconfig.DbConfig) error {
+		return nil
+	})
+	producerregistry.RegisterPublisher(testProducerType, func(conf *ezconfig.ProducerConfig) (producer.Publisher, error) {
+		return testPublisher{}, nil
+	}, func(conf *ezconfig.ProducerConfig) error {
+		return nil
+	})
+	producerregistry.RegisterPublisher(warningProducerType, func(conf *ezconfig.ProducerConfig) (producer.Publisher, error) {
+		return testPublisher{}, nil
+	}, func(conf *ezconfig.ProducerConfig) error {
+		conf.Log().Warn("producer warning")
+		return nil
+	})
+	producerregistry.RegisterPublisher(slowProducerType, func(conf *ezconfig.ProducerConfig) (producer.Publisher, error) {
+		slow := slowProducer.Load()
+		<-slow.release
+		publisher := &notifyingPublisher{}
+		slow.connected <- publisher
+		return publisher, nil
+	}, func(conf *ezconfig.ProducerConfig) error {
+		return nil
+	})
+	producerregistry.Register(legacyProducerType, func(conf *ezconfig.ProducerConfig) (producer.Producer, error) {
+		return &legacyProducer{}, nil
+	}, func(conf *ezconfig.ProducerConfig) error {
+		return nil
+	})
+}
+
+// testPublisher publishes nothing, successfully
+type testPublisher struct{}
+
+func (testPublisher) Publish(ctx context.Context, msg *producer.Message) error {
+	return nil
+}
+
+func (testPublisher) Close() error {
+	return nil
+}
+
+// legacyProducer records the topics it publishes to
+type legacyProducer struct {
+	topics []string
+}
+
+func (p *legacyProducer) Publish(topic string, message string) {
+	p.topics = append(p.topics, topic)
+}
+
+func (p *legacyProducer) Close() error {
+	return nil
+}
+
+func TestMain(m *testing.M) {
+	SetLogger(slog.New(slog.DiscardHandler))
+	os.Exit(m.Run())
+}
+
+func failingDbConfig() *ezconfig.DbConfig {
+	return &ezconfig.DbConfig{
+		Database: ezconfig.DbHost{Type: failingDbType},
+	}
+}
+
+func TestInitDbContext_canceled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := InitDbContext(ctx, failingDbConfig(), 100, backoff.Constant(time.Hour))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Retrying did not stop when the context was done (took %v)", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Error does not wrap the cancellation cause: %v", err)
+	}
+	if !errors.Is(err, errUnreachable) {
+		t.Fatalf("Error does not wrap the last connection error: %v", err)
+	}
+}
+
+func TestConnectContext_cause(t *testing.T) {
+	errShutdown := errors.New("shutting down")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(errShutdown)
+
+	_, err := New().
+		WithRetry(100, backoff.Constant(time.Hour)).
+		WithDatabase(failingDbConfig()).
+		ConnectContext(ctx)
+	if !errors.Is(err, errShutdown) {
+		t.Fatalf("Error does not wrap the cancellation cause: %v", err)
+	}
+}
+
+func TestConnect_noBackoff(t *testing.T) {
+	// retrying without a strategy does not wait between attempts
+	_, err := New().
+		WithRetry(3, nil).
+		WithDatabase(failingDbConfig()).
+		Connect()
+	if !errors.Is(err, errUnreachable) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestResolveRetry(t *testing.T) {
+	global := backoff.Constant(time.Second)
+	co := New().WithRetry(3, global)
+
+	conf := &ezconfig.RetryConfig{}
+	policy, err := co.resolveRetry(nil, conf)
+	if err != nil || policy.attempts != 3 || policy.strategy != global {
+		t.Fatalf("Expected the default policy, got %+v (%v)", policy, err)
+	}
+
+	conf = &ezconfig.RetryConfig{Attempts: 20, Backoff: "exponential", Initial: time.Millisecond, Max: time.Second}
+	policy, err = co.resolveRetry(nil, conf)
+	if err != nil || policy.attempts != 20 {
+		t.Fatalf("Expected the configured policy, got %+v (%v)", policy, err)
+	}
+	if wait := policy.strategy.Duration(3); wait != 4*time.Millisecond {
+		t.Fatalf("Unexpected configured backoff %v", wait)
+	}
+
+	co.WithDatabaseRetry(7, backoff.Constant(time.Minute))
+	policy, err = co.resolveRetry(co.retryOverrides[DatabaseName], conf)
+	if err != nil || policy.attempts != 7 || policy.strategy.Duration(0) != time.Minute {
+		t.Fatalf("Expected the overridden policy, got %+v (%v)", policy, err)
+	}
+
+	conf = &ezconfig.RetryConfig{Attempts: 5, Backoff: "linear"}
+	if _, err := co.resolveRetry(nil, conf); err == nil {
+		t.Fatal("Expected an error for an unknown backoff")
+	}
+}
+
+func TestConnect_invalidRetry(t *testing.T) {
+	analytics, _ := fakeResource("analytics", 0)
+	analytics.Optional = true
+	analytics.Retry = &ezconfig.RetryConfig{Attempts: 5, Backoff: "exponential", Initial: time.Second, Max: time.Millisecond}
+	if _, err := New().WithResource(analytics).Connect(); err == nil {
+		t.Fatal("Expected an optional resource with an invalid retry to fail Connect")
+	}
+}
+
+// fakeConn is a connection to a fake resource
+type fakeConn struct {
+	sync.Mutex
+	name   string
+	closed bool
+}
+
+func (f *fakeConn) Close() error {
+	f.Lock()
+	defer f.Unlock()
+	f.closed = true
+	return nil
+}
+
+func (f *fakeConn) isClosed() bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.closed
+}
+
+// fakeResource builds a resource that fails the given number of times before connecting
+func fakeResource(name string, failures int) (Resource, *fakeConn) {
+	conn := &fakeConn{name: name}
+	return Resource{
+		Name:   name,
+		Config: name,
+		Connect: func(ctx context.Context, config interface{}, deps *Connections) (io.Closer, error) {
+			if failures > 0 {
+				failures--
+				return nil, errUnreachable
+			}
+			return conn, nil
+		},
+	}, conn
+}
+
+func TestConnect_resources(t *testing.T) {
+	cache, cacheConn := fakeResource("cache", 2)
+	storage, storageConn := fakeResource("storage", 0)
+
+	connections, err := New().
+		WithRetry(3, nil).
+		WithResource(cache).
+		WithResource(storage).
+		Connect()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	conn, err := Get[*fakeConn](connections, "cache")
+	if err != nil || conn != cacheConn {
+		t.Fatalf("Unexpected cache connection %v (%v)", conn, err)
+	}
+	if _, err := Get[*sql.DB](connections, "cache"); err == nil {
+		t.Fatal("Expected an error for the wrong type")
+	}
+	if _, err := Get[*fakeConn](connections, "queue"); err == nil {
+		t.Fatal("Expected an error for an unknown resource")
+	}
+
+	if err := connections.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !cacheConn.isClosed() || !storageConn.isClosed() {
+		t.Fatal("Resources were not closed")
+	}
+}
+
+func TestConnect_duplicateResource(t *testing.T) {
+	first, _ := fakeResource("cache", 0)
+	second, _ := fakeResource("cache", 0)
+	if _, err := New().WithResource(first).WithResource(second).Connect(); err == nil {
+		t.Fatal("Expected an error for duplicate resource names")
+	}
+}
+
+func TestConnect_dependencies(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+
+	resource := func(name string, deps ...string) Resource {
+		return Resource{
+			Name:      name,
+			DependsOn: deps,
+			Connect: func(ctx context.Context, config interface{}, connected *Connections) (io.Closer, error) {
+				for _, dep := range deps {
+					if _, ok := connected.Get(dep); !ok {
+						t.Errorf("%s connected before %s", name, dep)
+					}
+				}
+				record("open " + name)
+				return closerFunc(func() error {
+					record("close " + name)
+					return nil
+				}), nil
+			},
+		}
+	}
+
+	connections, err := New().
+		WithResource(resource("relay", "outbox")).
+		WithResource(resource("outbox", "store")).
+		WithResource(resource("store")).
+		Connect()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	connections.Close()
+
+	expected := []string{"open store", "open outbox", "open relay", "close relay", "close outbox", "close store"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("Unexpected order %v", events)
+	}
+}
+
+func TestConnect_failedDependency(t *testing.T) {
+	store, _ := fakeResource("store", 1)
+	outbox, _ := fakeResource("outbox", 0)
+	outbox.DependsOn = []string{"store"}
+	connect := outbox.Connect
+	connected := false
+	outbox.Connect = func(ctx context.Context, config interface{}, deps *Connections) (io.Closer, error) {
+		connected = true
+		return connect(ctx, config, deps)
+	}
+
+	_, err := New().WithResource(outbox).WithResource(store).Connect()
+	if !errors.Is(err, errUnreachable) {
+		t.Fatalf("Expected the dependency's error, got %v", err)
+	}
+	if connected {
+		t.Fatal("Dependent resource should not have been connected")
+	}
+}
+
+func TestConnect_logger(t *testing.T) {
+	var buf bytes.Buffer
+	cache, _ := fakeResource("cache", 1)
+	cache.Address = "cache.internal:6379"
+
+	_, err := New().
+		WithLogger(slog.New(slog.NewJSONHandler(&buf, nil))).
+		WithRetry(2, backoff.Constant(time.Millisecond)).
+		WithResource(cache).
+		Connect()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(bytes.SplitN(buf.Bytes(), []byte("\n"), 2)[0], &line); err != nil {
+		t.Fatalf("Log line is not json: %v", err)
+	}
+	expected := map[string]interface{}{
+		"service":      "cache",
+		"attempt":      float64(1),
+		"max_attempts": float64(2),
+		"wait":         float64(time.Millisecond),
+		"error":        errUnreachable.Error(),
+		"address":      "cache.internal:6379",
+	}
+	for key, value := range expected {
+		if line[key] != value {
+			t.Errorf("Expected %s=%v, got %v", key, value, line[key])
+		}
+	}
+}
+
+func TestConnect_producerLogger(t *testing.T) {
+	var buf bytes.Buffer
+	conf := &ezconfig.ProducerConfig{Settings: ezconfig.ProducerSettings{Type: warningProducerType}}
+	connections, err := New().
+		WithLogger(slog.New(slog.NewTextHandler(&buf, nil))).
+		WithProducer(conf).
+		Connect()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer connections.Close()
+	if !bytes.Contains(buf.Bytes(), []byte("producer warning")) {
+		t.Fatalf("Expected the producer to log with the opener's logger, got %q", buf.String())
+	}
+	if conf.Logger != nil {
+		t.Fatal("The configuration should not be modified")
+	}
+}
+
+func TestConnect_hooks(t *testing.T) {
+	var mu sync.Mutex
+	var events []Event
+	hook := HookFunc(func(event Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	})
+	cache, _ := fakeResource("cache", 1)
+
+	connections, err := New().
+		WithHook(hook).
+		WithRetry(2, nil).
+		WithResource(cache).
+		Connect()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	connections.Close()
+
+	expected := []EventType{AttemptStarted, AttemptFailed, AttemptStarted, Connected, StateChanged, Closed, StateChanged}
+	if len(events) != len(expected) {
+		t.Fatalf("Unexpected events %v", events)
+	}
+	for i, event := range events {
+		if event.Type != expected[i] || event.Resource != "cache" {
+			t.Fatalf("Unexpected event %d: %+v", i, event)
+		}
+	}
+	if events[1].Err != errUnreachable || events[3].Attempt != 2 || events[3].Elapsed < events[3].Duration {
+		t.Fatalf("Unexpected event details %+v", events)
+	}
+	if events[4].Previous != StateConnecting || events[4].State != StateConnected || events[6].State != StateClosed {
+		t.Fatalf("Unexpected state changes %+v", events)
+	}
+}
+
+func TestMetricsHook(t *testing.T) {
+	registry := metrics.NewRegistry()
+	cache, _ := fakeResource("cache", 2)
+	store, _ := fakeResource("store", 5)
+
+	New().
+		WithHook(MetricsHook(registry)).
+		WithRetry(3, nil).
+		WithResource(cache).
+		WithResource(store).
+		Connect()
+
+	if value := registry.Counter("ezconfig_connect_attempts_total", "", "resource").Value("cache"); value != 3 {
+		t.Fatalf("Expected 3 attempts, got %v", value)
+	}
+	if value := registry.Counter("ezconfig_connect_gave_up_total", "", "resource").Value("store"); value != 1 {
+		t.Fatalf("Expected store to give up, got %v", value)
+	}
+	if count := registry.Histogram("ezconfig_connect_attempts", "", nil, "resource").Count("cache"); count != 1 {
+		t.Fatalf("Expected one connection, got %v", count)
+	}
+}
+
+func TestConnect_allErrors(t *testing.T) {
+	cache, _ := fakeResource("cache", 1)
+	store, storeConn := fakeResource("store", 0)
+	queue, _ := fakeResource("queue", 1)
+
+	_, err := New().WithResource(cache).WithResource(store).WithResource(queue).Connect()
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	var resourceErr *ResourceError
+	if !errors.As(err, &resourceErr) || !errors.Is(resourceErr, errUnreachable) {
+		t.Fatalf("Expected a resource error, got %v", err)
+	}
+	failed := ResourceErrors(err)
+	if len(failed) != 2 || failed[0].Resource != "cache" || failed[1].Resource != "queue" {
+		t.Fatalf("Expected cache and queue to fail, got %v", failed)
+	}
+
+	// the connected resource is closed before Connect returns
+	if !storeConn.isClosed() {
+		t.Fatal("Connected resource was not closed")
+	}
+}
+
+func TestConnections_Close_allErrors(t *testing.T) {
+	errClose := errors.New("close failed")
+	failing := func(name string) Resource {
+		return Resource{
+			Name: name,
+			Connect: func(ctx context.Context, config interface{}, deps *Connections) (io.Closer, error) {
+				return closerFunc(func() error { return errClose }), nil
+			},
+		}
+	}
+	connections, err := New().WithResource(failing("a")).WithResource(failing("b")).Connect()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	failed := ResourceErrors(connections.Close())
+	if len(failed) != 2 || failed[0].Resource != "a" || failed[1].Resource != "b" {
+		t.Fatalf("Expected a and b to fail, got %v", failed)
+	}
+}
+
+// switchableResource builds a resource that fails until available is set
+func switchableResource(name string, available *atomic.Bool) (Resource, *fakeConn) {
+	conn := &fakeConn{name: name}
+	return Resource{
+		Name: name,
+		Connect: func(ctx context.Context, config interface{}, deps *Connections) (io.Closer, error) {
+			if !available.Load() {
+				return nil, errUnreachable
+			}
+			return conn, nil
+		},
+	}, conn
+}
+
+// waitForState waits for a resource to reach a state
+func waitForState(t *testing.T, connections *Connections, name string, state State) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for connections.Status()[name].State != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s is %v, expected %v", name, connections.Status()[name].State, state)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConnect_optional(t *testing.T) {
+	var available atomic.Bool
+	analytics, analyticsConn := switchableResource("analytics", &available)
+	store, _ := fakeResource("store", 0)
+
+	connections, err := New().
+		WithOptional("analytics").
+		WithReconnectInterval(time.Millisecond).
+		WithResource(analytics).
+		WithResource(store).
+		Connect()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	status := connections.Status()
+	if status["store"].State != StateConnected || status["store"].Optional {
+		t.Fatalf("Unexpected store status %+v", status["store"])
+	}
+	if status["analytics"].State != StateDegraded || !status["analytics"].Optional || status["analytics"].Err != errUnreachable {
+		t.Fatalf("Unexpected analytics status %+v", status["analytics"])
+	}
+	if !connections.Degraded() {
+		t.Fatal("Expected connections to be degraded")
+	}
+	if _, ok := connections.Get("analytics"); ok {
+		t.Fatal("Degraded resource should not be available")
+	}
+
+	available.Store(true)
+	waitForState(t, connections, "analytics", StateConnected)
+	if conn, err := Get[*fakeConn](connections, "analytics"); err != nil || conn != analyticsConn {
+		t.Fatalf("Unexpected analytics connection %v (%v)", conn, err)
+	}
+	if connections.Degraded() {
+		t.Fatal("Expected connections to have recovered")
+	}
+
+	if err := connections.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !analyticsConn.isClosed() {
+		t.Fatal("Reconnected resource was not closed")
+	}
+	waitForState(t, connections, "analytics", StateClosed)
+}
+
+func TestConnect_optionalDependency(t *testing.T) {
+	var available atomic.Bool
+	store, _ := switchableResource("store", &available)
+	store.Optional = true
+	outbox, outboxConn := fakeResource("outbox", 0)
+	outbox.DependsOn = []string{"store"}
+	outbox.Optional = true
+
+	connections, err := New().
+		WithReconnectInterval(time.Millisecond).
+		WithResource(store).
+		WithResource(outbox).
+		Connect()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer connections.Close()
+	if status := connections.Status()["outbox"]; status.State != StateDegraded {
+		t.Fatalf("Unexpected outbox status %+v", status)
+	}
+
+	available.Store(true)
+	waitForState(t, connections, "outbox", StateConnected)
+	if conn, _ := connections.Get("outbox"); conn != outboxConn {
+		t.Fatalf("Unexpected outbox connection %v", conn)
+	}
+
+	// a required resource cannot depend on one that failed
+	required, _ := fakeResource("outbox", 0)
+	required.DependsOn = []string{"store"}
+	available.Store(false)
+	_, err = New().WithResource(store).WithResource(required).Connect()
+	if failed := ResourceErrors(err); len(failed) != 1 || failed[0].Resource != "outbox" {
+		t.Fatalf("Expected outbox to fail, got %v", err)
+	}
+}
+
+func TestConnections_Close_stopsReconnecting(t *testing.T) {
+	var attempts atomic.Int32
+	analytics := Resource{
+		Name:     "analytics",
+		Optional: true,
+		Connect: func(ctx context.Context, config interface{}, deps *Connections) (io.Closer, error) {
+			attempts.Add(1)
+			return nil, errUnreachable
+		},
+	}
+	connections, err := New().WithReconnectInterval(time.Millisecond).WithResource(analytics).Connect()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for attempts.Load() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := connections.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	closed := attempts.Load()
+	time.Sleep(10 * time.Millisecond)
+	if attempts.Load() != closed {
+		t.Fatal("Resource was reconnected after Close")
+	}
+	if status := connections.Status()["analytics"]; status.State != StateClosed {
+		t.Fatalf("Unexpected status %+v", status)
+	}
+}
+
+func TestConnect_supervisor(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	var mu sync.Mutex
+	var changes []State
+	hook := HookFunc(func(event Event) {
+		if event.Type == StateChanged && event.Resource == "cache" {
+			mu.Lock()
+			defer mu.Unlock()
+			changes = append(changes, event.State)
+		}
+	})
+
+	cache := Resource{
+		Name: "cache",
+		Connect: func(ctx context.Context, config interface{}, deps *Connections) (io.Closer, error) {
+			return &fakeConn{name: "cache"}, nil
+		},
+		Check: func(ctx context.Context, conn io.Closer) error {
+			if !healthy.Load() {
+				return errUnreachable
+			}
+			return nil
+		},
+	}
+
+	connections, err := New().
+		WithHook(hook).
+		WithSupervisor(time.Millisecond).
+		WithResource(cache).
+		Connect()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	handle := NewHandle[*fakeConn](connections, "cache")
+	first, err := handle.Get()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// the connection is replaced once a check fails
+	healthy.Store(false)
+	deadline := time.Now().Add(time.Second)
+	for !first.isClosed() {
+		if time.Now().After(deadline) {
+			t.Fatal("Unhealthy connection was not replaced")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	healthy.Store(true)
+	waitForState(t, connections, "cache", StateConnected)
+	if current, err := handle.Get(); err != nil || current == first {
+		t.Fatalf("Expected a new connection, got %v (%v)", current, err)
+	}
+	connections.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []State{StateConnected, StateReconnecting, StateConnected}
+	if len(changes) < len(expected) || !reflect.DeepEqual(changes[:len(expected)], expected) {
+		t.Fatalf("Unexpected state changes %v", changes)
+	}
+	if changes[len(changes)-1] != StateClosed {
+		t.Fatalf("Expected the resource to be closed, got %v", changes)
+	}
+}
+
+// fakeConnector is a database connector that never connects
+type fakeConnector struct{}
+
+func (fakeConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return nil, errUnreachable
+}
+
+func (fakeConnector) Driver() driver.Driver {
+	return nil
+}
+
+func TestConnect_supervisorFields(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	database := Resource{
+		Name: DatabaseName,
+		Connect: func(ctx context.Context, config interface{}, deps *Connections) (io.Closer, error) {
+			return sql.OpenDB(fakeConnector{}), nil
+		},
+		Check: func(ctx context.Context, conn io.Closer) error {
+			if !healthy.Load() {
+				return errUnreachable
+			}
+			return nil
+		},
+	}
+	var given *sql.DB
+	var handle *Handle[*sql.DB]
+	outbox := Resource{
+		Name:      "outbox",
+		DependsOn: []string{DatabaseName},
+		Connect: func(ctx context.Context, config interface{}, deps *Connections) (io.Closer, error) {
+			given = deps.DB
+			handle = NewHandle[*sql.DB](deps, DatabaseName)
+			return &fakeConn{name: "outbox"}, nil
+		},
+	}
+	connections, err := New().WithSupervisor(time.Millisecond).WithResource(database).WithResource(outbox).Connect()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer connections.Close()
+	first := connections.DB
+	if first == nil || given != first {
+		t.Fatal("Expected DB to be set for the connections and the outbox")
+	}
+
+	// the fields are read without a lock while the database is reconnected
+	stop := make(chan struct{})
+	read := make(chan struct{})
+	go func() {
+		defer close(read)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				if connections.DB != first {
+					t.Error("DB was replaced")
+				}
+			}
+		}
+	}()
+
+	// the replaced connection is closed once a check fails
+	healthy.Store(false)
+	deadline := time.Now().Add(time.Second)
+	for errors.Is(first.Ping(), errUnreachable) {
+		if time.Now().After(deadline) {
+			t.Fatal("Unhealthy connection was not replaced")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	healthy.Store(true)
+	waitForState(t, connections, DatabaseName, StateConnected)
+	close(stop)
+	<-read
+
+	// handles on the dependencies follow reconnects
+	current, _ := connections.Get(DatabaseName)
+	db, err := handle.Get()
+	if err != nil || db != current || db == first {
+		t.Fatalf("Expected the outbox handle to return the new connection, got %v (%v)", db, err)
+	}
+	if err := db.Ping(); !errors.Is(err, errUnreachable) {
+		t.Fatalf("Expected the new connection to be open, got %v", err)
+	}
+}
+
+// notifyingPublisher records the OnDelivery handler it is given
+type notifyingPublisher struct {
+	testPublisher
+	handler atomic.Pointer[producer.DeliveryHandler]
+	closed  atomic.Bool
+}
+
+func (p *notifyingPublisher) Close() error {
+	p.closed.Store(true)
+	return nil
+}
+
+func (p *notifyingPublisher) isClosed() bool {
+	return p.closed.Load()
+}
+
+func (p *notifyingPublisher) OnDelivery(handler producer.DeliveryHandler) {
+	p.handler.Store(&handler)
+}
+
+func TestConnect_supervisorPublisher(t *testing.T) {
+	var healthy atomic.Bool
+	var connects atomic.Int32
+	healthy.Store(true)
+	resource := Resource{
+		Name: ProducerName,
+		Connect: func(ctx context.Context, config interface{}, deps *Connections) (io.Closer, error) {
+			connects.Add(1)
+			return &notifyingPublisher{}, nil
+		},
+		Check: func(ctx context.Context, conn io.Closer) error {
+			if !healthy.Load() {
+				return errUnreachable
+			}
+			return nil
+		},
+	}
+	connections, err := New().WithSupervisor(time.Millisecond).WithResource(resource).Connect()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer connections.Close()
+	publisher := connections.Publisher
+	var delivered atomic.Int32
+	publisher.(producer.Notifier).OnDelivery(func(delivery producer.Delivery) {
+		delivered.Add(1)
+	})
+
+	healthy.Store(false)
+	deadline := time.Now().Add(time.Second)
+	for connects.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("Unhealthy producer was not replaced")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	healthy.Store(true)
+	waitForState(t, connections, ProducerName, StateConnected)
+
+	if connections.Publisher != publisher {
+		t.Fatal("Publisher was replaced")
+	}
+	if err := connections.Publisher.Publish(context.Background(), &producer.Message{Topic: "events"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// the handler was given to the new connection
+	current, _ := Get[*notifyingPublisher](connections, ProducerName)
+	handler := current.handler.Load()
+	if handler == nil {
+		t.Fatal("OnDelivery handler was not set on the new connection")
+	}
+	(*handler)(producer.Delivery{})
+	if delivered.Load() != 1 {
+		t.Fatal("Deliveries did not reach the handler")
+	}
+}
+
+func TestConnect_optionalProducer(t *testing.T) {
+	resource := Resource{
+		Name:     ProducerName,
+		Optional: true,
+		Connect: func(ctx context.Context, config interface{}, deps *Connections) (io.Closer, error) {
+			return nil, errUnreachable
+		},
+	}
+	connections, err := New().WithReconnectInterval(time.Hour).WithResource(resource).Connect()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer connections.Close()
+
+	// a degraded producer fails to publish rather than leaving Publisher nil
+	err = connections.Publisher.Publish(context.Background(), &producer.Message{Topic: "events"})
+	var resourceErr *ResourceError
+	if !errors.As(err, &resourceErr) || resourceErr.Resource != ProducerName || !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("Expected a *ResourceError wrapping ErrNotConnected, got %v", err)
+	}
+	connections.Producer.Publish("events", "hello")
+}
+
+func TestConnections_CloseContext(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	store, storeConn := fakeResource("store", 0)
+	stuck := Resource{
+		Name:      "outbox",
+		DependsOn: []string{"store"},
+		Connect: func(ctx context.Context, config interface{}, deps *Connections) (io.Closer, error) {
+			return closerFunc(func() error {
+				<-release
+				return nil
+			}), nil
+		},
+	}
+	connections, err := New().WithResource(store).WithResource(stuck).Connect()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = connections.CloseContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected a deadline error, got %v", err)
+	}
+	failed := ResourceErrors(err)
+	if len(failed) != 2 || failed[0].Resource != "outbox" || failed[1].Resource != "store" {
+		t.Fatalf("Expected outbox and store to time out, got %v", failed)
+	}
+	if storeConn.isClosed() {
+		t.Fatal("Dependency closed before its dependent")
+	}
+}
+
+func TestConnections_Close_usesConnections(t *testing.T) {
+	var connections *Connections
+	store, _ := fakeResource("store", 0)
+	outbox := Resource{
+		Name:      "outbox",
+		DependsOn: []string{"store"},
+		Connect: func(ctx context.Context, config interface{}, deps *Connections) (io.Closer, error) {
+			// a closer may use the connections, such as a consumer flushing to the store
+			return closerFunc(func() error {
+				if _, ok := connections.Status()["outbox"]; !ok {
+					return errors.New("Status is missing outbox")
+				}
+				_, err := Get[*fakeConn](connections, "store")
+				return err
+			}), nil
+		},
+	}
+	var err error
+	connections, err = New().WithResource(store).WithResource(outbox).Connect()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := connections.CloseContext(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+// contextCloser records whether it was closed with CloseContext
+type contextCloser struct {
+	withContext atomic.Bool
+}
+
+func (c *contextCloser) Close() error {
+	return nil
+}
+
+func (c *contextCloser) CloseContext(ctx context.Context) error {
+	c.withContext.Store(true)
+	return nil
+}
+
+func TestConnections_CloseContext_forwarded(t *testing.T) {
+	conn := &contextCloser{}
+	outbox := Resource{
+		Name: "outbox",
+		Connect: func(ctx context.Context, config interface{}, deps *Connections) (io.Closer, error) {
+			return conn, nil
+		},
+	}
+	connections, err := New().WithResource(outbox).Connect()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := connections.CloseContext(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !conn.withContext.Load() {
+		t.Fatal("Expected the resource to be closed with CloseContext")
+	}
+}
+
+// countingResource builds a resource that counts its connections
+func countingResource(name string, connects *atomic.Int32, deps ...string) Resource {
+	return Resource{
+		Name:      name,
+		DependsOn: deps,
+		Connect: func(ctx context.Context, config interface{}, connected *Connections) (io.Closer, error) {
+			for _, dep := range deps {
+				if _, ok := connected.Get(dep); !ok {
+					return nil, fmt.Errorf("%s connected before %s", name, dep)
+				}
+			}
+			connects.Add(1)
+			time.Sleep(time.Millisecond)
+			return &fakeConn{name: name}, nil
+		},
+	}
+}
+
+func TestConnect_lazy(t *testing.T) {
+	var storeConnects, outboxConnects atomic.Int32
+	connections, err := New().
+		WithLazy().
+		WithResource(countingResource("store", &storeConnects)).
+		WithResource(countingResource("outbox", &outboxConnects, "store")).
+		Connect()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if storeConnects.Load() != 0 || connections.Status()["store"].State != StateIdle {
+		t.Fatal("Lazy resources should not be connected by Connect")
+	}
+	if _, ok := connections.Get("store"); ok {
+		t.Fatal("Get should not connect lazy resources")
+	}
+
+	// concurrent first callers share a single connection
+	var wg sync.WaitGroup
+	conns := make([]*fakeConn, 10)
+	for i := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := GetContext[*fakeConn](context.Background(), connections, "outbox")
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			conns[i] = conn
+		}()
+	}
+	wg.Wait()
+	if storeConnects.Load() != 1 || outboxConnects.Load() != 1 {
+		t.Fatalf("Expected one connection each, got %d and %d", storeConnects.Load(), outboxConnects.Load())
+	}
+	for _, conn := range conns {
+		if conn != conns[0] {
+			t.Fatal("Callers received different connections")
+		}
+	}
+
+	store, _ := connections.Get("store")
+	connections.Close()
+	if !conns[0].isClosed() || !store.(*fakeConn).isClosed() {
+		t.Fatal("Lazy resources were not closed")
+	}
+	var unused atomic.Int32
+	closed, _ := New().WithLazy().WithResource(countingResource("store", &unused)).Connect()
+	closed.Close()
+	if _, err := closed.GetContext(context.Background(), "store"); !errors.Is(err, errClosed) {
+		t.Fatalf("Expected an error after Close, got %v", err)
+	}
+}
+
+func TestConnect_lazyErrors(t *testing.T) {
+	store, storeConn := fakeResource("store", 2)
+	outbox, _ := fakeResource("outbox", 0)
+	outbox.DependsOn = []string{"store"}
+	connections, err := New().WithLazy().WithResource(store).WithResource(outbox).Connect()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer connections.Close()
+
+	// the error is the one Connect would have returned
+	failing, _ := fakeResource("store", 1)
+	_, eager := New().WithResource(failing).Connect()
+	_, err = connections.GetContext(context.Background(), "store")
+	if err == nil || err.Error() != eager.Error() {
+		t.Fatalf("Expected %v, got %v", eager, err)
+	}
+
+	_, err = Get[*fakeConn](connections, "outbox")
+	if failed := ResourceErrors(err); len(failed) != 2 || failed[0].Resource != "store" || failed[1].Resource != "outbox" {
+		t.Fatalf("Expected store and outbox to fail, got %v", err)
+	}
+
+	// failed resources are connected again on next use
+	conn, err := NewHandle[*fakeConn](connections, "store").Get()
+	if err != nil || conn != storeConn {
+		t.Fatalf("Unexpected store connection %v (%v)", conn, err)
+	}
+}
+
+func TestConnect_lazyFields(t *testing.T) {
+	database := Resource{
+		Name: DatabaseName,
+		Connect: func(ctx context.Context, config interface{}, deps *Connections) (io.Closer, error) {
+			return sql.OpenDB(fakeConnector{}), nil
+		},
+	}
+	connections, err := New().
+		WithLazy().
+		WithResource(database).
+		WithProducer(&ezconfig.ProducerConfig{Settings: ezconfig.ProducerSettings{Type: testProducerType}}).
+		WithConsumer(&ezconfig.ConsumerConfig{Settings: ezconfig.ConsumerSettings{Type: "dummy"}}).
+		Connect()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer connections.Close()
+	if connections.DB != nil || connections.Publisher == nil || connections.Producer == nil || connections.Consumer == nil {
+		t.Fatal("Expected Publisher, Producer and Consumer to be set, and DB not to be")
+	}
+
+	// the publisher connects the producer on first use
+	publisher := connections.Publisher
+	if err := connections.Publisher.Publish(context.Background(), &producer.Message{Topic: "events"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if connections.Status()[ProducerName].State != StateConnected || connections.Publisher != publisher {
+		t.Fatal("Expected the producer to connect behind the same Publisher")
+	}
+
+	if _, err := Get[*sql.DB](connections, DatabaseName); err != nil || connections.DB != nil {
+		t.Fatalf("Expected the database to connect without setting DB, got %v", err)
+	}
+}
+
+func TestConnect_metrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	conf := &ezconfig.ProducerConfig{Settings: ezconfig.ProducerSettings{Type: testProducerType}}
+	connections, err := New().WithMetrics(registry).WithProducer(conf).Connect()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer connections.Close()
+
+	connections.Publisher.Publish(context.Background(), &producer.Message{Topic: "events"})
+	connections.Producer.Publish("events", "hello")
+	if value := registry.Counter("ezconfig_producer_messages_total", "", "topic").Value("events"); value != 2 {
+		t.Fatalf("Expected 2 messages, got %v", value)
+	}
+	if value := registry.Counter("ezconfig_connect_attempts_total", "", "resource").Value(ProducerName); value != 1 {
+		t.Fatalf("Expected 1 attempt, got %v", value)
+	}
+}
+
+func TestConnect_producerCancelled(t *testing.T) {
+	slow := &slowInit{release: make(chan struct{}), connected: make(chan *notifyingPublisher, 1)}
+	slowProducer.Store(slow)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	conf := &ezconfig.ProducerConfig{Settings: ezconfig.ProducerSettings{Type: slowProducerType}}
+	_, err := New().WithProducer(conf).ConnectContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the attempt to be cancelled, got %v", err)
+	}
+
+	// the connection made once the attempt was abandoned is closed
+	close(slow.release)
+	publisher := <-slow.connected
+	deadline := time.Now().Add(time.Second)
+	for !publisher.isClosed() {
+		if time.Now().After(deadline) {
+			t.Fatal("Late connection was not closed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConnect_legacyProducer(t *testing.T) {
+	conf := &ezconfig.ProducerConfig{Settings: ezconfig.ProducerSettings{Type: legacyProducerType}}
+	connections, err := New().WithProducer(conf).Connect()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer connections.Close()
+
+	if err := connections.Publisher.Publish(context.Background(), &producer.Message{Topic: "events"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	connections.Producer.Publish("events", "hello")
+	conn, _ := Get[producer.Publisher](connections, ProducerName)
+	legacy, ok := producer.ToProducer(conn, nil).(*legacyProducer)
+	if !ok || !reflect.DeepEqual(legacy.topics, []string{"events", "events"}) {
+		t.Fatalf("Expected the registered producer, got %#v", conn)
+	}
+}
+
+func TestConnect_consumer(t *testing.T) {
+	connections, err := New().
+		WithProducer(&ezconfig.ProducerConfig{Settings: ezconfig.ProducerSettings{Type: testProducerType}}).
+		WithConsumer(&ezconfig.ConsumerConfig{Settings: ezconfig.ConsumerSettings{Type: "dummy"}}).
+		Connect()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer connections.Close()
+
+	// the consumer is closed before the producer its handlers may use
+	if !reflect.DeepEqual(connections.levels, [][]string{{ProducerName}, {ConsumerName}}) {
+		t.Fatalf("Unexpected levels %v", connections.levels)
+	}
+
+	dummy.Send(&consumer.Message{Topic: "opener_test_consumer", Value: []byte("hello")})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err = connections.Consumer.Subscribe(ctx, []string{"opener_test_consumer"}, func(ctx context.Context, msg *consumer.Message) error {
+		defer cancel()
+		return connections.Publisher.Publish(ctx, &producer.Message{Topic: "replies", Value: msg.Value})
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
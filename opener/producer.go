@@ -1,50 +1,115 @@
 package opener
 
 import (
+	"context"
 	"fmt"
-	"log"
-	"time"
+	"io"
+	"strings"
 
 	"github.com/explodes/ezconfig"
 	"github.com/explodes/ezconfig/backoff"
+	"github.com/explodes/ezconfig/metrics"
 	"github.com/explodes/ezconfig/producer"
 	"github.com/explodes/ezconfig/producer/registry"
 )
 
+const (
+	// ProducerName is the name of the producer resource created by WithProducer
+	ProducerName = "producer"
+)
+
 // InitProducer establishes a connection to a Producer with the given strategy
 func InitProducer(conf *ezconfig.ProducerConfig, attempts int, wait backoff.Strategy) (producer.Producer, error) {
+	return InitProducerContext(context.Background(), conf, attempts, wait)
+}
+
+// InitProducerContext establishes a connection to a Producer with the given strategy.
+// Retrying stops as soon as ctx is done.
+func InitProducerContext(ctx context.Context, conf *ezconfig.ProducerConfig, attempts int, wait backoff.Strategy) (producer.Producer, error) {
+	conf = producerConfigLogger(conf, defaultLogger())
+	publisher, err := InitPublisherContext(ctx, conf, attempts, wait)
+	if err != nil {
+		return nil, err
+	}
+	return producer.ToProducer(publisher, conf.Logger), nil
+}
+
+// InitPublisher establishes a connection to a Publisher with the given strategy
+func InitPublisher(conf *ezconfig.ProducerConfig, attempts int, wait backoff.Strategy) (producer.Publisher, error) {
+	return InitPublisherContext(context.Background(), conf, attempts, wait)
+}
+
+// InitPublisherContext establishes a connection to a Publisher with the given strategy.
+// Retrying stops as soon as ctx is done.
+func InitPublisherContext(ctx context.Context, conf *ezconfig.ProducerConfig, attempts int, wait backoff.Strategy) (producer.Publisher, error) {
+	resource, err := ProducerResource(producerConfigLogger(conf, defaultLogger()))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := connectWithRetries(ctx, &resource, newConnections(), retryPolicy{attempts: attempts, strategy: wait}, &observer{log: defaultLogger()})
+	if err != nil {
+		return nil, err
+	}
+	return conn.(producer.Publisher), nil
+}
+
+// ProducerResource validates producer configuration and builds a Resource that connects to it.
+// Producers implementing producer.Checker are checked with it.
+func ProducerResource(conf *ezconfig.ProducerConfig) (Resource, error) {
 	// determine type
 	factory, ok := registry.Get(conf.Settings.Type)
 	if !ok {
-		return nil, fmt.Errorf("Invalid producer type %s (was the producer type imported?)", conf.Settings.Type)
+		return Resource{}, fmt.Errorf("Invalid producer type %s (was the producer type imported?)", conf.Settings.Type)
 	}
 	// validate
 	if err := factory.Validate(conf); err != nil {
-		return nil, err
+		return Resource{}, err
 	}
-	return initProducerWithRetries(conf, factory.Init, attempts, wait)
+	return Resource{
+		Name:    ProducerName,
+		Address: producerAddress(conf),
+		Config:  conf,
+		Connect: connectProducer(factory.InitPublisher),
+		Check:   checkProducer,
+		Retry:   &conf.Settings.Retry,
+	}, nil
 }
 
-// initProducerWithRetries attempts to connect to a producer a given number of times.
-// If attempts is less than or equal to one, only one attempt will be made.
-func initProducerWithRetries(conf *ezconfig.ProducerConfig, init registry.InitFunc, attempts int, wait backoff.Strategy) (producer.Producer, error) {
-	if attempts <= 0 {
-		attempts = 1
+// connectProducer builds a ConnectFunc that creates a publisher with init,
+// giving up once ctx is done
+func connectProducer(init registry.PublisherInitFunc) ConnectFunc {
+	return func(ctx context.Context, config interface{}, deps *Connections) (io.Closer, error) {
+		return initContext(ctx, func() (producer.Publisher, error) {
+			return init(config.(*ezconfig.ProducerConfig))
+		})
 	}
-	var p producer.Producer
-	var err error
-	for attempt := 0; attempt < attempts; attempt++ {
-		p, err = init(conf)
+}
+
+// instrumentProducer wraps a ConnectFunc so that the producer records metrics in the registry
+func instrumentProducer(connect ConnectFunc, registry *metrics.Registry) ConnectFunc {
+	return func(ctx context.Context, config interface{}, deps *Connections) (io.Closer, error) {
+		conn, err := connect(ctx, config, deps)
 		if err != nil {
-			log.Printf("Unable to create producer (attempt %d of %d)", attempt+1, attempts)
-			time.Sleep(wait.Duration(attempt))
-			continue
+			return nil, err
 		}
-		break
+		conf := config.(*ezconfig.ProducerConfig)
+		return producer.Instrument(conn.(producer.Publisher), registry, conf.Log()), nil
 	}
-	if err != nil {
-		log.Printf("Unable to create producer after %d tries", attempts)
-		return nil, err
+}
+
+// checkProducer checks the producer if it is a producer.Checker
+func checkProducer(ctx context.Context, conn io.Closer) error {
+	if checker, ok := conn.(producer.Checker); ok {
+		return checker.Check(ctx)
+	}
+	return nil
+}
+
+// producerAddress lists the producer hosts for logging
+func producerAddress(conf *ezconfig.ProducerConfig) string {
+	addresses := make([]string, len(conf.Hosts))
+	for i := range conf.Hosts {
+		addresses[i] = conf.Hosts[i].Address()
 	}
-	return p, err
+	return strings.Join(addresses, ",")
 }
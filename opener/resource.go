@@ -0,0 +1,147 @@
+package opener
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/explodes/ezconfig"
+)
+
+// ConnectFunc connects to a resource using its configuration.
+// deps holds the connections to the resources it depends on.
+// The returned connection must be ready to use.
+type ConnectFunc func(ctx context.Context, config interface{}, deps *Connections) (io.Closer, error)
+
+// CheckFunc checks the health of a connected resource
+type CheckFunc func(ctx context.Context, conn io.Closer) error
+
+// Resource is anything the Opener can connect to, such as a database, a producer,
+// a cache or an HTTP client.
+//
+//	cache := opener.Resource{
+//		Name:   "redis",
+//		Config: &config.Redis,
+//		Connect: func(ctx context.Context, config interface{}, deps *opener.Connections) (io.Closer, error) {
+//			return dialRedis(ctx, config.(*RedisConfig))
+//		},
+//	}
+//
+// Resources may depend on each other. A resource is connected once all of its
+// dependencies are, and is closed before any of them.
+//
+//	outbox := opener.Resource{
+//		Name:      "outbox",
+//		DependsOn: []string{opener.DatabaseName, opener.ProducerName},
+//		Connect: func(ctx context.Context, config interface{}, deps *opener.Connections) (io.Closer, error) {
+//			return newOutboxRelay(deps.DB, deps.Publisher), nil
+//		},
+//	}
+type Resource struct {
+	// Name uniquely identifies the resource within an Opener
+	Name string
+
+	// Address optionally describes where the resource is for logging.
+	// It must not contain credentials.
+	Address string
+
+	// Config is passed to Connect
+	Config interface{}
+
+	// Connect establishes the connection
+	Connect ConnectFunc
+
+	// Check optionally checks the health of an established connection
+	Check CheckFunc
+
+	// Retry optionally configures the attempts made to connect, see Opener.WithRetry
+	Retry *ezconfig.RetryConfig
+
+	// DependsOn names the resources that must be connected before this one
+	DependsOn []string
+
+	// Optional resources do not fail Connect when they cannot be connected,
+	// see Opener.WithOptional
+	Optional bool
+}
+
+// validate makes sure the resource can be connected to
+func (r *Resource) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("Resource name not specified")
+	}
+	if r.Connect == nil {
+		return fmt.Errorf("Resource %s has no connect function", r.Name)
+	}
+	if r.Retry != nil && r.Retry.Enabled() {
+		if _, err := r.Retry.Strategy(); err != nil {
+			return fmt.Errorf("Invalid retry for resource %s: %w", r.Name, err)
+		}
+	}
+	return nil
+}
+
+// connectWithRetries attempts to connect to a resource a given number of times.
+// If attempts is less than or equal to one, only one attempt will be made.
+// Retrying stops as soon as ctx is done.
+func connectWithRetries(ctx context.Context, r *Resource, deps *Connections, retry retryPolicy, obs *observer) (io.Closer, error) {
+	attempts := retry.attempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+	start := time.Now()
+	giveUp := func(attempt int, err error) (io.Closer, error) {
+		obs.log.Error("Unable to connect, giving up",
+			"service", r.Name,
+			"max_attempts", attempts,
+			"error", err,
+			"address", r.Address)
+		obs.emit(Event{Type: GaveUp, Resource: r.Name, Attempt: attempt, MaxAttempts: attempts, Elapsed: time.Since(start), Err: err})
+		return nil, err
+	}
+
+	var err error
+	if ctx.Err() != nil {
+		return giveUp(0, abortedError(ctx, r.Name, nil))
+	}
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			if waitErr := wait(ctx, retry.strategy, attempt-1); waitErr != nil {
+				return giveUp(attempt, abortedError(ctx, r.Name, err))
+			}
+		}
+		obs.emit(Event{Type: AttemptStarted, Resource: r.Name, Attempt: attempt + 1, MaxAttempts: attempts, Elapsed: time.Since(start)})
+		attemptStart := time.Now()
+		var conn io.Closer
+		conn, err = r.Connect(ctx, r.Config, deps)
+		if err == nil {
+			obs.log.Debug("Connected",
+				"service", r.Name,
+				"attempt", attempt+1,
+				"max_attempts", attempts,
+				"address", r.Address)
+			obs.emit(Event{Type: Connected, Resource: r.Name, Attempt: attempt + 1, MaxAttempts: attempts,
+				Duration: time.Since(attemptStart), Elapsed: time.Since(start)})
+			return conn, nil
+		}
+
+		var next time.Duration
+		if attempt+1 < attempts && retry.strategy != nil {
+			next = retry.strategy.Duration(attempt)
+		}
+		obs.log.Warn("Unable to connect",
+			"service", r.Name,
+			"attempt", attempt+1,
+			"max_attempts", attempts,
+			"wait", next,
+			"error", err,
+			"address", r.Address)
+		obs.emit(Event{Type: AttemptFailed, Resource: r.Name, Attempt: attempt + 1, MaxAttempts: attempts,
+			Duration: time.Since(attemptStart), Elapsed: time.Since(start), Wait: next, Err: err})
+		if ctx.Err() != nil {
+			return giveUp(attempt+1, abortedError(ctx, r.Name, err))
+		}
+	}
+	return giveUp(attempts, err)
+}
@@ -0,0 +1,30 @@
+package opener
+
+import (
+	"github.com/explodes/ezconfig"
+	"github.com/explodes/ezconfig/backoff"
+)
+
+// retryPolicy is the number of attempts to make to a service and the
+// backoff strategy to utilize when re-attempting
+type retryPolicy struct {
+	attempts int
+	strategy backoff.Strategy
+}
+
+// resolveRetry picks the retry policy for a service. In order of precedence it is
+// the policy set on the Opener for that service, the [*.retry] section of the
+// service's configuration, and finally the policy set with WithRetry.
+func (co *Opener) resolveRetry(override *retryPolicy, conf *ezconfig.RetryConfig) (retryPolicy, error) {
+	if override != nil {
+		return *override, nil
+	}
+	if conf != nil && conf.Enabled() {
+		strategy, err := conf.Strategy()
+		if err != nil {
+			return retryPolicy{}, err
+		}
+		return retryPolicy{attempts: conf.Attempts, strategy: strategy}, nil
+	}
+	return retryPolicy{attempts: co.retries, strategy: co.backoff}, nil
+}
@@ -0,0 +1,62 @@
+package opener
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/explodes/ezconfig/backoff"
+)
+
+// wait sleeps for the backoff period following a failed attempt.
+// It returns early with the cancellation cause if ctx is done first.
+func wait(ctx context.Context, strategy backoff.Strategy, attempt int) error {
+	var duration time.Duration
+	if strategy != nil {
+		duration = strategy.Duration(attempt)
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-timer.C:
+		return nil
+	}
+}
+
+// abortedError builds the error returned when ctx is done before a connection
+// is established. It wraps both the cancellation cause and the last connection error.
+func abortedError(ctx context.Context, service string, lastErr error) error {
+	if lastErr == nil {
+		return fmt.Errorf("Connecting to %s aborted: %w", service, context.Cause(ctx))
+	}
+	return fmt.Errorf("Connecting to %s aborted: %w (last error: %w)", service, context.Cause(ctx), lastErr)
+}
+
+// initContext runs init, which cannot be cancelled, in a goroutine and returns once
+// it finishes or ctx is done. A connection it makes after ctx is done is closed.
+func initContext[T io.Closer](ctx context.Context, init func() (T, error)) (T, error) {
+	type result struct {
+		conn T
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := init()
+		done <- result{conn: conn, err: err}
+	}()
+	select {
+	case r := <-done:
+		return r.conn, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.err == nil {
+				r.conn.Close()
+			}
+		}()
+		var zero T
+		return zero, context.Cause(ctx)
+	}
+}
@@ -0,0 +1,429 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"sync/atomic"
+
+	"github.com/explodes/ezconfig"
+	"github.com/explodes/ezconfig/internal/tlsconf"
+	"github.com/explodes/ezconfig/producer"
+	"github.com/explodes/ezconfig/producer/registry"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// amqpProducerType is the value to use in configuration to connect to this producer type
+	amqpProducerType = "amqp"
+
+	// pendingConfirms is how many messages published in async mode may wait
+	// for their confirmation before Publish blocks
+	pendingConfirms = 1024
+)
+
+var (
+	// errClosed is returned when publishing to a closed producer
+	errClosed = errors.New("Producer is closed")
+
+	// errConnectionClosed is returned when the connection to the broker was lost
+	errConnectionClosed = errors.New("Connection to the broker is closed")
+)
+
+// init registers the init and validation functions with the registry
+func init() {
+	registry.RegisterPublisher(amqpProducerType, initProducer, validateConfig)
+}
+
+// validateConfig makes sure all the required settings are present for the producer
+func validateConfig(conf *ezconfig.ProducerConfig) error {
+	if len(conf.Hosts) == 0 {
+		return errors.New("Invalid producer configuration: No [[producers]] entry in configuration")
+	}
+	if err := conf.Settings.ValidateMode(); err != nil {
+		return err
+	}
+	options := conf.Settings.AMQP
+	if options.Heartbeat < 0 {
+		return errors.New("Heartbeat must not be negative")
+	}
+	if options.DialTimeout < 0 {
+		return errors.New("Dial_timeout must not be negative")
+	}
+	if !options.Confirm && conf.Settings.Async() {
+		conf.Log().Warn("amqp producer in async mode without confirm cannot report messages that were not delivered")
+	} else if !options.Confirm {
+		conf.Log().Warn("amqp producer in sync mode without confirm reports messages as delivered once they are sent, before the broker has them")
+	}
+	if conf.Settings.SASL.Enabled() {
+		return errors.New("Sasl only applies to kafka, set the amqp username and password in [producer.amqp]")
+	}
+	if (options.Username == "") != (options.Password == "") {
+		return errors.New("Amqp username and password must be specified together")
+	}
+	if conf.Settings.TLS.Enabled() {
+		if err := tlsconf.Validate(conf.Settings.TLS); err != nil {
+			return err
+		}
+		if _, err := tlsconf.Load(conf.Settings.TLS); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dialConfig builds the configuration of connections to the broker
+func dialConfig(conf *ezconfig.ProducerConfig) (amqp091.Config, error) {
+	options := conf.Settings.AMQP
+	config := amqp091.Config{
+		Vhost:     options.VHost,
+		Heartbeat: options.Heartbeat,
+	}
+	if config.Vhost == "" {
+		config.Vhost = "/"
+	}
+	if options.DialTimeout > 0 {
+		config.Dial = amqp091.DefaultDial(options.DialTimeout)
+	}
+	if options.Username != "" {
+		config.SASL = []amqp091.Authentication{&amqp091.PlainAuth{Username: options.Username, Password: options.Password}}
+	}
+	if conf.Settings.TLS.Enabled() {
+		tlsConfig, err := tlsconf.Load(conf.Settings.TLS)
+		if err != nil {
+			return config, err
+		}
+		config.TLSClientConfig = tlsConfig
+	}
+	return config, nil
+}
+
+// brokerURL is the URL of a broker, without credentials
+func brokerURL(conf *ezconfig.ProducerConfig, host ezconfig.ProducerHost) string {
+	u := url.URL{Scheme: "amqp", Host: host.Address(), Path: "/"}
+	if conf.Settings.TLS.Enabled() {
+		u.Scheme = "amqps"
+	}
+	return u.String()
+}
+
+// initProducer connects to the first broker that can be reached, in order
+func initProducer(conf *ezconfig.ProducerConfig) (producer.Publisher, error) {
+	config, err := dialConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, host := range conf.Hosts {
+		// the connection fills in the server name of its tls config
+		hostConfig := config
+		if config.TLSClientConfig != nil {
+			hostConfig.TLSClientConfig = config.TLSClientConfig.Clone()
+		}
+		conn, err := amqp091.DialConfig(brokerURL(conf, host), hostConfig)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Unable to connect to %s: %w", host.Address(), err))
+			continue
+		}
+		broker := &amqpConnection{conn: conn}
+		ch, err := broker.channel(conf.Settings.AMQP.Confirm)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return newAMQPProducer(ch, broker, conf), nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// channel publishes messages to the broker
+type channel interface {
+
+	// publish publishes a message, returning its confirmation in confirm mode, nil otherwise
+	publish(ctx context.Context, exchange, key string, msg amqp091.Publishing) (confirmation, error)
+
+	// IsClosed reports whether the channel was closed, by the broker when a message
+	// is published to an exchange that does not exist
+	IsClosed() bool
+
+	Close() error
+}
+
+// confirmation is the broker's acknowledgement of a message, an *amqp091.DeferredConfirmation
+type confirmation interface {
+
+	// Done is closed once the broker acknowledged or rejected the message
+	Done() <-chan struct{}
+
+	// Acked reports whether the broker acknowledged the message
+	Acked() bool
+}
+
+// amqpChannel is a channel backed by an amqp091.Channel
+type amqpChannel struct {
+	ch *amqp091.Channel
+}
+
+// publish publishes a message on the channel. Messages are not mandatory, so the
+// broker acknowledges a message that no queue is bound to receive and drops it:
+// a confirmation means the exchange accepted the message, not that it was routed.
+func (c *amqpChannel) publish(ctx context.Context, exchange, key string, msg amqp091.Publishing) (confirmation, error) {
+	confirm, err := c.ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, false, false, msg)
+	if err != nil || confirm == nil {
+		return nil, err
+	}
+	return confirm, nil
+}
+
+// IsClosed reports whether the channel was closed
+func (c *amqpChannel) IsClosed() bool {
+	return c.ch.IsClosed()
+}
+
+// Close closes the channel
+func (c *amqpChannel) Close() error {
+	return c.ch.Close()
+}
+
+// connection is the connection to the broker
+type connection interface {
+
+	// channel opens a channel to publish messages on, in confirm mode if asked to
+	channel(confirm bool) (channel, error)
+
+	IsClosed() bool
+	Close() error
+}
+
+// amqpConnection is a connection backed by an amqp091.Connection
+type amqpConnection struct {
+	conn *amqp091.Connection
+}
+
+// channel opens a channel on the connection
+func (c *amqpConnection) channel(confirm bool) (channel, error) {
+	ch, err := c.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	if confirm {
+		if err := ch.Confirm(false); err != nil {
+			ch.Close()
+			return nil, err
+		}
+	}
+	return &amqpChannel{ch: ch}, nil
+}
+
+// IsClosed reports whether the connection was closed
+func (c *amqpConnection) IsClosed() bool {
+	return c.conn.IsClosed()
+}
+
+// Close closes the connection
+func (c *amqpConnection) Close() error {
+	return c.conn.Close()
+}
+
+// newAMQPProducer publishes on ch, tracking the confirmations of async messages in the background
+func newAMQPProducer(ch channel, conn connection, conf *ezconfig.ProducerConfig) *amqpProducer {
+	a := &amqpProducer{
+		ch:      ch,
+		conn:    conn,
+		conf:    conf,
+		async:   conf.Settings.Async(),
+		pending: make(chan pending, pendingConfirms),
+	}
+	a.drain.Add(1)
+	go a.drainConfirms()
+	return a
+}
+
+// amqpProducer publishes messages to an amqp broker.
+// In sync mode Publish waits for the broker to confirm the message, when confirm is set.
+type amqpProducer struct {
+	conn  connection
+	conf  *ezconfig.ProducerConfig
+	async bool
+
+	// chMu guards ch, which is replaced when the broker closes it
+	chMu sync.Mutex
+	ch   channel
+
+	// handler receives the outcome of messages published in async mode
+	handler atomic.Pointer[producer.DeliveryHandler]
+
+	// mu guards publishing, which must not happen once closed
+	mu     sync.RWMutex
+	closed bool
+
+	// pending holds the messages published in async mode waiting for their confirmation
+	pending chan pending
+	drain   sync.WaitGroup
+}
+
+// pending is a message waiting for its confirmation
+type pending struct {
+	message *producer.Message
+	confirm confirmation
+}
+
+// Publish publishes a message to the exchange and routing key of its topic. In sync
+// mode it waits for the broker to confirm it, in async mode the outcome is sent to the
+// OnDelivery handler.
+func (a *amqpProducer) Publish(ctx context.Context, msg *producer.Message) error {
+	if !a.async {
+		_, err := a.Deliver(ctx, msg)
+		return err
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	confirm, err := a.publish(ctx, msg)
+	if err != nil || confirm == nil {
+		return err
+	}
+	select {
+	case a.pending <- pending{message: msg, confirm: confirm}:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("Message to %s was not tracked: %w", msg.Topic, context.Cause(ctx))
+	}
+}
+
+// Deliver publishes a message and waits for the broker to confirm it, when confirm is set.
+// Without confirm it returns once the message is sent.
+func (a *amqpProducer) Deliver(ctx context.Context, msg *producer.Message) (producer.Delivery, error) {
+	a.mu.RLock()
+	confirm, err := a.publish(ctx, msg)
+	a.mu.RUnlock()
+	if err != nil || confirm == nil {
+		return producer.Delivery{Message: msg, Err: err}, err
+	}
+	select {
+	case <-confirm.Done():
+		delivery := delivered(msg, confirm)
+		return delivery, delivery.Err
+	case <-ctx.Done():
+		err := fmt.Errorf("Message to %s was not confirmed: %w", msg.Topic, context.Cause(ctx))
+		return producer.Delivery{Message: msg, Err: err}, err
+	}
+}
+
+// publish publishes a message on the channel. a.mu must be held for reading.
+func (a *amqpProducer) publish(ctx context.Context, msg *producer.Message) (confirmation, error) {
+	if a.closed {
+		return nil, errClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("Message to %s was not sent: %w", msg.Topic, context.Cause(ctx))
+	}
+	ch, err := a.channel()
+	if err != nil {
+		return nil, err
+	}
+	exchange, key := route(msg.Topic, a.conf.Settings.AMQP.Exchange)
+	confirm, err := ch.publish(ctx, exchange, key, publishing(msg, a.conf.Settings.AMQP.Persistent))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to publish to %s: %w", msg.Topic, err)
+	}
+	return confirm, nil
+}
+
+// channel returns the channel to publish on, opening a new one if the broker closed it.
+// Messages waiting for their confirmation on a closed channel are reported as rejected.
+func (a *amqpProducer) channel() (channel, error) {
+	a.chMu.Lock()
+	defer a.chMu.Unlock()
+	if !a.ch.IsClosed() {
+		return a.ch, nil
+	}
+	if a.conn.IsClosed() {
+		return nil, errConnectionClosed
+	}
+	ch, err := a.conn.channel(a.conf.Settings.AMQP.Confirm)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to reopen the channel: %w", err)
+	}
+	a.ch = ch
+	return ch, nil
+}
+
+// delivered is the outcome of a confirmed message
+func delivered(msg *producer.Message, confirm confirmation) producer.Delivery {
+	delivery := producer.Delivery{Message: msg}
+	if !confirm.Acked() {
+		delivery.Err = fmt.Errorf("Message to %s was rejected by the broker", msg.Topic)
+	}
+	return delivery
+}
+
+// OnDelivery sets the handler called with the outcome of messages published in async mode.
+// Outcomes are only known when confirm is set.
+func (a *amqpProducer) OnDelivery(handler producer.DeliveryHandler) {
+	a.handler.Store(&handler)
+}
+
+// drainConfirms reports the outcome of messages published in async mode, in order
+func (a *amqpProducer) drainConfirms() {
+	defer a.drain.Done()
+	for p := range a.pending {
+		<-p.confirm.Done()
+		delivery := delivered(p.message, p.confirm)
+		if handler := a.handler.Load(); handler != nil {
+			(*handler)(delivery)
+		} else if delivery.Err != nil {
+			a.conf.Log().Error("Unable to publish", "topic", p.message.Topic, "error", delivery.Err)
+		}
+	}
+}
+
+// Check makes sure the connection to the broker is open, and reopens the channel
+// if the broker closed it
+func (a *amqpProducer) Check(ctx context.Context) error {
+	if a.conn.IsClosed() {
+		return errConnectionClosed
+	}
+	_, err := a.channel()
+	return err
+}
+
+// Close waits for the confirmations of messages published in async mode,
+// and closes the connection to the broker
+func (a *amqpProducer) Close() error {
+	return a.CloseContext(context.Background())
+}
+
+// CloseContext is Close, but stops waiting for confirmations once ctx is done.
+// The messages that were not confirmed by then are reported as rejected.
+func (a *amqpProducer) CloseContext(ctx context.Context) error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+	close(a.pending)
+	a.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		a.drain.Wait()
+		close(drained)
+	}()
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = fmt.Errorf("Confirmations did not finish: %w", context.Cause(ctx))
+	}
+
+	// closing the channel rejects any confirmation still pending
+	a.chMu.Lock()
+	defer a.chMu.Unlock()
+	if !a.ch.IsClosed() {
+		err = errors.Join(err, a.ch.Close())
+	}
+	return errors.Join(err, a.conn.Close())
+}
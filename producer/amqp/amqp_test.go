@@ -0,0 +1,394 @@
+package amqp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/explodes/ezconfig"
+	"github.com/explodes/ezconfig/producer"
+	"github.com/explodes/ezconfig/producer/registry"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestDetermineFactory(t *testing.T) {
+	factory, ok := registry.Get(amqpProducerType)
+	if !ok {
+		t.Fatal("AMQP factory not registered")
+	}
+	sf1 := reflect.ValueOf(initProducer)
+	sf2 := reflect.ValueOf(factory.InitPublisher)
+	if sf1.Pointer() != sf2.Pointer() {
+		t.Fatal("Unexpected init function")
+	}
+	sf1 = reflect.ValueOf(validateConfig)
+	sf2 = reflect.ValueOf(factory.Validate)
+	if sf1.Pointer() != sf2.Pointer() {
+		t.Fatal("Unexpected validate function")
+	}
+}
+
+// fakeConfirmation is a confirmation resolved by the fake broker
+type fakeConfirmation struct {
+	done  chan struct{}
+	acked bool
+}
+
+func (c *fakeConfirmation) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *fakeConfirmation) Acked() bool {
+	return c.acked
+}
+
+// published is a message received by the fake broker
+type published struct {
+	exchange, key string
+	msg           amqp091.Publishing
+}
+
+// fakeBroker is an in-process stand-in for a channel to a broker.
+// In confirm mode it acknowledges messages, or rejects them once reject is set,
+// as soon as they are published unless hold is set. Closing it rejects the held messages.
+type fakeBroker struct {
+	confirm bool
+
+	mu        sync.Mutex
+	published []published
+	held      []*fakeConfirmation
+	hold      bool
+	reject    bool
+	err       error
+	closed    bool
+}
+
+func (b *fakeBroker) publish(ctx context.Context, exchange, key string, msg amqp091.Publishing) (confirmation, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, amqp091.ErrClosed
+	}
+	if b.err != nil {
+		return nil, b.err
+	}
+	b.published = append(b.published, published{exchange: exchange, key: key, msg: msg})
+	if !b.confirm {
+		return nil, nil
+	}
+	c := &fakeConfirmation{done: make(chan struct{}), acked: !b.reject}
+	if b.hold {
+		b.held = append(b.held, c)
+	} else {
+		close(c.done)
+	}
+	return c, nil
+}
+
+// release confirms the held messages
+func (b *fakeBroker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, c := range b.held {
+		close(c.done)
+	}
+	b.held = nil
+}
+
+func (b *fakeBroker) IsClosed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
+
+func (b *fakeBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for _, c := range b.held {
+		c.acked = false
+		close(c.done)
+	}
+	b.held = nil
+	return nil
+}
+
+// fakeConnection is the connection to a fake broker, whose channel it reopens
+type fakeConnection struct {
+	broker *fakeBroker
+
+	mu     sync.Mutex
+	opened int
+	closed bool
+}
+
+func (c *fakeConnection) channel(confirm bool) (channel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.opened++
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	c.broker.closed = false
+	return c.broker, nil
+}
+
+func (c *fakeConnection) IsClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *fakeConnection) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+// fakeProducer creates a producer publishing to a fake broker
+func fakeProducer(t *testing.T, mode string, options ezconfig.AMQPOptions) (*amqpProducer, *fakeBroker) {
+	conf := &ezconfig.ProducerConfig{Settings: ezconfig.ProducerSettings{Mode: mode, AMQP: options}}
+	broker := &fakeBroker{confirm: options.Confirm}
+	a := newAMQPProducer(broker, &fakeConnection{broker: broker}, conf)
+	t.Cleanup(func() { a.Close() })
+	return a, broker
+}
+
+func TestAMQPProducer_Publish(t *testing.T) {
+	a, broker := fakeProducer(t, ezconfig.ProducerModeSync, ezconfig.AMQPOptions{Exchange: "events", Confirm: true, Persistent: true})
+
+	msg := &producer.Message{Topic: "order.created", Key: []byte("order-42"), Value: []byte("hello")}
+	if err := a.Publish(context.Background(), msg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := a.Publish(context.Background(), &producer.Message{Topic: "billing/invoice.paid"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	first, second := broker.published[0], broker.published[1]
+	if first.exchange != "events" || first.key != "order.created" || second.exchange != "billing" || second.key != "invoice.paid" {
+		t.Fatalf("Unexpected routes %s %s and %s %s", first.exchange, first.key, second.exchange, second.key)
+	}
+	if first.msg.DeliveryMode != amqp091.Persistent || string(first.msg.Body) != "hello" {
+		t.Fatalf("Unexpected publishing %+v", first.msg)
+	}
+
+	broker.reject = true
+	if err := a.Publish(context.Background(), msg); err == nil {
+		t.Fatal("Expected an error for a rejected message")
+	}
+	broker.err = amqp091.ErrClosed
+	if err := a.Publish(context.Background(), msg); !errors.Is(err, amqp091.ErrClosed) {
+		t.Fatalf("Expected a closed channel error, got %v", err)
+	}
+
+	if err := a.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := a.Publish(context.Background(), msg); err != errClosed {
+		t.Fatalf("Expected a closed error, got %v", err)
+	}
+	if !broker.IsClosed() || a.Check(context.Background()) == nil {
+		t.Fatal("Expected the connection to be closed")
+	}
+}
+
+func TestAMQPProducer_channelClosed(t *testing.T) {
+	a, broker := fakeProducer(t, ezconfig.ProducerModeSync, ezconfig.AMQPOptions{Exchange: "missing", Confirm: true})
+	conn := a.conn.(*fakeConnection)
+
+	// the broker closes the channel of a message published to a missing exchange
+	broker.Close()
+	if err := a.Check(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if conn.opened != 1 || broker.IsClosed() {
+		t.Fatal("Expected Check to reopen the channel")
+	}
+
+	broker.Close()
+	if err := a.Publish(context.Background(), &producer.Message{Topic: "orders"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if conn.opened != 2 {
+		t.Fatal("Expected Publish to reopen the channel")
+	}
+
+	broker.Close()
+	conn.Close()
+	if err := a.Check(context.Background()); err != errConnectionClosed {
+		t.Fatalf("Expected a closed connection error, got %v", err)
+	}
+}
+
+func TestAMQPProducer_Publish_unconfirmed(t *testing.T) {
+	a, broker := fakeProducer(t, "", ezconfig.AMQPOptions{})
+	delivery, err := a.Deliver(context.Background(), &producer.Message{Topic: "orders"})
+	if err != nil || delivery.Err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if msg := broker.published[0]; msg.exchange != "" || msg.key != "orders" || msg.msg.DeliveryMode != amqp091.Transient {
+		t.Fatalf("Expected a transient message to the default exchange, got %+v", msg)
+	}
+}
+
+func TestAMQPProducer_Deliver_timeout(t *testing.T) {
+	a, broker := fakeProducer(t, "", ezconfig.AMQPOptions{Confirm: true})
+	broker.hold = true
+	defer broker.release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := a.Deliver(ctx, &producer.Message{Topic: "orders"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected a deadline error, got %v", err)
+	}
+}
+
+func TestAMQPProducer_async(t *testing.T) {
+	a, broker := fakeProducer(t, "async", ezconfig.AMQPOptions{Confirm: true})
+	broker.hold = true
+	deliveries := make(chan producer.Delivery, 2)
+	a.OnDelivery(func(delivery producer.Delivery) {
+		deliveries <- delivery
+	})
+
+	// Publish returns before the message is confirmed
+	for _, topic := range []string{"first", "second"} {
+		if err := a.Publish(context.Background(), &producer.Message{Topic: topic}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	select {
+	case delivery := <-deliveries:
+		t.Fatalf("Unexpected delivery before confirmation: %+v", delivery)
+	default:
+	}
+
+	// Close waits for pending confirmations
+	closed := make(chan error)
+	go func() { closed <- a.Close() }()
+	broker.release()
+	if err := <-closed; err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, topic := range []string{"first", "second"} {
+		delivery := <-deliveries
+		if delivery.Err != nil || delivery.Message.Topic != topic {
+			t.Fatalf("Unexpected delivery %+v", delivery)
+		}
+	}
+}
+
+func TestAMQPProducer_CloseContext(t *testing.T) {
+	a, broker := fakeProducer(t, "async", ezconfig.AMQPOptions{Confirm: true})
+	broker.hold = true
+	deliveries := make(chan producer.Delivery, 1)
+	a.OnDelivery(func(delivery producer.Delivery) {
+		deliveries <- delivery
+	})
+	if err := a.Publish(context.Background(), &producer.Message{Topic: "orders"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// the broker never confirms the message, so closing gives up once ctx is done
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := a.CloseContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected a deadline error, got %v", err)
+	}
+	if delivery := <-deliveries; delivery.Err == nil {
+		t.Fatal("Expected the unconfirmed message to be reported as failed")
+	}
+	if !broker.IsClosed() || !a.conn.IsClosed() {
+		t.Fatal("Expected the channel and connection to be closed")
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	valid := func() *ezconfig.ProducerConfig {
+		return &ezconfig.ProducerConfig{
+			Settings: ezconfig.ProducerSettings{
+				Type: amqpProducerType,
+				AMQP: ezconfig.AMQPOptions{VHost: "/orders", Username: "orders", Password: "hunter2", Confirm: true},
+			},
+			Hosts: []ezconfig.ProducerHost{{Host: "localhost", Port: 5672}},
+		}
+	}
+	if err := validateConfig(valid()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	invalid := map[string]func(conf *ezconfig.ProducerConfig){
+		"hosts":     func(conf *ezconfig.ProducerConfig) { conf.Hosts = nil },
+		"mode":      func(conf *ezconfig.ProducerConfig) { conf.Settings.Mode = "batch" },
+		"sasl":      func(conf *ezconfig.ProducerConfig) { conf.Settings.SASL.Username = "orders" },
+		"password":  func(conf *ezconfig.ProducerConfig) { conf.Settings.AMQP.Password = "" },
+		"heartbeat": func(conf *ezconfig.ProducerConfig) { conf.Settings.AMQP.Heartbeat = -time.Second },
+		"ca file":   func(conf *ezconfig.ProducerConfig) { conf.Settings.TLS.CAFile = "/missing/ca.pem" },
+	}
+	for name, invalidate := range invalid {
+		conf := valid()
+		invalidate(conf)
+		if err := validateConfig(conf); err == nil {
+			t.Errorf("Expected an error for an invalid %s", name)
+		}
+	}
+
+	// without confirm, the default async mode and sync mode both warn
+	for _, mode := range []string{"", ezconfig.ProducerModeSync} {
+		var buf bytes.Buffer
+		conf := valid()
+		conf.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+		conf.Settings.Mode = mode
+		conf.Settings.AMQP.Confirm = false
+		if err := validateConfig(conf); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !strings.Contains(buf.String(), "without confirm") {
+			t.Errorf("Expected a warning in mode %q, got %q", mode, buf.String())
+		}
+	}
+}
+
+func TestDialConfig(t *testing.T) {
+	conf := &ezconfig.ProducerConfig{Settings: ezconfig.ProducerSettings{
+		TLS:  ezconfig.TLSConfig{Enable: true},
+		AMQP: ezconfig.AMQPOptions{VHost: "/orders", Username: "orders", Password: "hunter2", Heartbeat: 10 * time.Second, DialTimeout: time.Second},
+	}}
+	config, err := dialConfig(conf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.Vhost != "/orders" || config.Heartbeat != 10*time.Second || config.Dial == nil || config.TLSClientConfig == nil {
+		t.Fatalf("Unexpected config %+v", config)
+	}
+	if auth, ok := config.SASL[0].(*amqp091.PlainAuth); !ok || auth.Username != "orders" || auth.Password != "hunter2" {
+		t.Fatalf("Unexpected authentication %+v", config.SASL)
+	}
+
+	if printed := fmt.Sprint(conf.Settings.AMQP); strings.Contains(printed, "hunter2") {
+		t.Fatalf("Password printed in %s", printed)
+	}
+
+	// credentials are never part of the URL, which may be logged
+	url := brokerURL(conf, ezconfig.ProducerHost{Host: "rabbit.internal", Port: 5671})
+	if url != "amqps://rabbit.internal:5671/" {
+		t.Fatalf("Unexpected url %s", url)
+	}
+}
+
+func TestInitProducer_unreachable(t *testing.T) {
+	conf := &ezconfig.ProducerConfig{
+		Settings: ezconfig.ProducerSettings{AMQP: ezconfig.AMQPOptions{DialTimeout: 100 * time.Millisecond}},
+		Hosts:    []ezconfig.ProducerHost{{Host: "127.0.0.1", Port: 1}, {Host: "127.0.0.1", Port: 2}},
+	}
+	if _, err := initProducer(conf); err == nil {
+		t.Fatal("Expected an error when no broker can be reached")
+	}
+}
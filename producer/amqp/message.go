@@ -0,0 +1,43 @@
+package amqp
+
+import (
+	"strings"
+
+	"github.com/explodes/ezconfig/producer"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// keyHeader is the header holding the key of messages, which amqp has no place for
+const keyHeader = "message_key"
+
+// route maps a topic to the exchange and routing key it is published to.
+// Topics of the form "exchange/routing.key" name their exchange, other topics
+// are routing keys of the configured exchange.
+func route(topic, exchange string) (string, string) {
+	if name, key, ok := strings.Cut(topic, "/"); ok {
+		return name, key
+	}
+	return exchange, topic
+}
+
+// publishing maps a message to an amqp publishing
+func publishing(msg *producer.Message, persistent bool) amqp091.Publishing {
+	publishing := amqp091.Publishing{
+		Body:         msg.Value,
+		Timestamp:    msg.Timestamp,
+		DeliveryMode: amqp091.Transient,
+	}
+	if persistent {
+		publishing.DeliveryMode = amqp091.Persistent
+	}
+	if len(msg.Headers) > 0 || msg.Key != nil {
+		publishing.Headers = make(amqp091.Table, len(msg.Headers)+1)
+		for name, value := range msg.Headers {
+			publishing.Headers[name] = value
+		}
+		if msg.Key != nil {
+			publishing.Headers[keyHeader] = msg.Key
+		}
+	}
+	return publishing
+}
@@ -0,0 +1,51 @@
+package amqp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/explodes/ezconfig/producer"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+func TestRoute(t *testing.T) {
+	routes := []struct {
+		topic, exchange       string
+		expectedExchange, key string
+	}{
+		{"orders", "", "", "orders"},
+		{"order.created", "events", "events", "order.created"},
+		{"billing/invoice.paid", "events", "billing", "invoice.paid"},
+		{"billing/", "", "billing", ""},
+	}
+	for _, r := range routes {
+		exchange, key := route(r.topic, r.exchange)
+		if exchange != r.expectedExchange || key != r.key {
+			t.Errorf("Unexpected route of %q: %q %q", r.topic, exchange, key)
+		}
+	}
+}
+
+func TestPublishing(t *testing.T) {
+	now := time.Now()
+	msg := &producer.Message{
+		Topic:     "orders",
+		Key:       []byte("order-42"),
+		Value:     []byte("shipped"),
+		Headers:   map[string][]byte{"trace": []byte("abc")},
+		Timestamp: now,
+	}
+	expected := amqp091.Publishing{
+		Headers:      amqp091.Table{"trace": []byte("abc"), keyHeader: []byte("order-42")},
+		DeliveryMode: amqp091.Persistent,
+		Timestamp:    now,
+		Body:         []byte("shipped"),
+	}
+	if p := publishing(msg, true); !reflect.DeepEqual(p, expected) {
+		t.Fatalf("Unexpected publishing %+v", p)
+	}
+	if p := publishing(&producer.Message{Topic: "orders"}, false); p.Headers != nil || p.DeliveryMode != amqp091.Transient {
+		t.Fatalf("Unexpected publishing %+v", p)
+	}
+}
@@ -1,7 +1,8 @@
 package dummy
 
 import (
-	"log"
+	"context"
+	"log/slog"
 
 	"github.com/explodes/ezconfig"
 	"github.com/explodes/ezconfig/producer"
@@ -15,27 +16,37 @@ const (
 
 // init registers the init and validation functions with the registry
 func init() {
-	registry.Register(dummyProducerType, initProducer, validateConfig)
+	registry.RegisterPublisher(dummyProducerType, initProducer, validateConfig)
 }
 
 // validateConfig makes sure all the required settings are present for the database
 func validateConfig(conf *ezconfig.ProducerConfig) error {
-	return nil
+	return conf.Settings.ValidateMode()
 }
 
 // initProducer establishes a connection with the given configuration
-func initProducer(conf *ezconfig.ProducerConfig) (producer.Producer, error) {
-	dummy := dummyProducer{}
+func initProducer(conf *ezconfig.ProducerConfig) (producer.Publisher, error) {
+	dummy := dummyProducer{log: conf.Log()}
 	return &dummy, nil
 }
 
-// dummyProducer is a stand-in producer that emits messages only to stdout
+// dummyProducer is a stand-in producer that emits messages only to its logger
 type dummyProducer struct {
+	log *slog.Logger
 }
 
-// Publish "publishes" a message to stdout
-func (d dummyProducer) Publish(topic string, message string) {
-	log.Printf("publish %q -> %s", topic, message)
+// Publish "publishes" a message to the logger
+func (d dummyProducer) Publish(ctx context.Context, msg *producer.Message) error {
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	d.log.Info("Publish", "topic", msg.Topic, "key", string(msg.Key), "value", string(msg.Value))
+	return nil
+}
+
+// Check always succeeds for dummyProducers
+func (d dummyProducer) Check(ctx context.Context) error {
+	return nil
 }
 
 // Close is a no-op for dummyProducers
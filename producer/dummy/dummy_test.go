@@ -1,12 +1,17 @@
 package dummy
 
 import (
+	"bytes"
+	"context"
+	"log/slog"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/explodes/ezconfig"
 	"github.com/explodes/ezconfig/backoff"
 	"github.com/explodes/ezconfig/opener"
+	"github.com/explodes/ezconfig/producer"
 	"github.com/explodes/ezconfig/producer/registry"
 )
 
@@ -16,7 +21,7 @@ func TestDetermineFactory(t *testing.T) {
 		t.Fatal("Dummy factory not registered")
 	}
 	sf1 := reflect.ValueOf(initProducer)
-	sf2 := reflect.ValueOf(factory.Init)
+	sf2 := reflect.ValueOf(factory.InitPublisher)
 	if sf1.Pointer() != sf2.Pointer() {
 		t.Fatal("Unexpected init function")
 	}
@@ -28,12 +33,23 @@ func TestDetermineFactory(t *testing.T) {
 }
 
 func TestDummyProducer_Publish(t *testing.T) {
-	dummy := dummyProducer{}
-	dummy.Publish("foo", "bar")
+	var buf bytes.Buffer
+	dummy := dummyProducer{log: slog.New(slog.NewTextHandler(&buf, nil))}
+	if err := dummy.Publish(context.Background(), &producer.Message{Topic: "foo", Key: []byte("k"), Value: []byte("bar")}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "topic=foo key=k value=bar") {
+		t.Fatalf("Expected the message to be logged, got %q", buf.String())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := dummy.Publish(ctx, &producer.Message{Topic: "foo"}); err != context.Canceled {
+		t.Fatalf("Expected a cancellation error, got %v", err)
+	}
 }
 
 func TestDummyProducer_Close(t *testing.T) {
-	dummy := dummyProducer{}
+	dummy := dummyProducer{log: slog.Default()}
 	dummy.Close()
 }
 
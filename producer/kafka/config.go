@@ -0,0 +1,160 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/explodes/ezconfig"
+	"github.com/explodes/ezconfig/internal/kafkaauth"
+)
+
+// requiredAcks are the accepted values for acks
+var requiredAcks = map[string]sarama.RequiredAcks{
+	"all":    sarama.WaitForAll,
+	"leader": sarama.WaitForLocal,
+	"none":   sarama.NoResponse,
+}
+
+// compressionCodecs are the accepted values for compression
+var compressionCodecs = map[string]sarama.CompressionCodec{
+	"none":   sarama.CompressionNone,
+	"gzip":   sarama.CompressionGZIP,
+	"snappy": sarama.CompressionSnappy,
+	"lz4":    sarama.CompressionLZ4,
+	"zstd":   sarama.CompressionZSTD,
+}
+
+// partitioners are the accepted values for partitioner
+var partitioners = map[string]sarama.PartitionerConstructor{
+	"hash":   newPartitioner,
+	"random": newRandomPartitioner,
+	"manual": newManualPartitioner,
+}
+
+// newConfig builds the sarama configuration of a producer, and makes sure it is valid
+func newConfig(conf *ezconfig.ProducerConfig) (*sarama.Config, error) {
+	options := conf.Settings.Kafka
+	if err := validateOptions(conf); err != nil {
+		return nil, err
+	}
+
+	config := sarama.NewConfig()
+	config.Producer.Retry.Max = conf.Settings.Retries
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Return.Successes = true
+	config.Producer.Return.Errors = true
+	config.Producer.Partitioner = newPartitioner
+	if options.ClientID != "" {
+		config.ClientID = options.ClientID
+	}
+	if options.Version != "" {
+		version, err := sarama.ParseKafkaVersion(options.Version)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid kafka version %q: %w", options.Version, err)
+		}
+		config.Version = version
+	}
+	if options.Acks != "" {
+		config.Producer.RequiredAcks = requiredAcks[options.Acks]
+	}
+	if options.Compression != "" {
+		config.Producer.Compression = compressionCodecs[options.Compression]
+	}
+	if options.CompressionLevel != nil {
+		config.Producer.CompressionLevel = *options.CompressionLevel
+	}
+	if options.Idempotent {
+		// sarama only guarantees ordering with a single request in flight
+		config.Producer.Idempotent = true
+		config.Net.MaxOpenRequests = 1
+	}
+	if options.MaxMessageBytes > 0 {
+		config.Producer.MaxMessageBytes = options.MaxMessageBytes
+	}
+	config.Producer.Flush.Frequency = options.FlushFrequency
+	config.Producer.Flush.Bytes = options.FlushBytes
+	config.Producer.Flush.Messages = options.FlushMessages
+	if options.Partitioner != "" {
+		config.Producer.Partitioner = partitioners[options.Partitioner]
+	}
+	if options.RetryBackoff > 0 {
+		config.Producer.Retry.Backoff = options.RetryBackoff
+	}
+	if options.Timeout > 0 {
+		config.Producer.Timeout = options.Timeout
+	}
+	if options.DialTimeout > 0 {
+		config.Net.DialTimeout = options.DialTimeout
+	}
+	if options.ReadTimeout > 0 {
+		config.Net.ReadTimeout = options.ReadTimeout
+	}
+	if options.WriteTimeout > 0 {
+		config.Net.WriteTimeout = options.WriteTimeout
+	}
+	if err := kafkaauth.Configure(config, conf.Settings.TLS, conf.Settings.SASL); err != nil {
+		return nil, err
+	}
+
+	// catches combinations of settings, such as zstd compression with a version before 2.1.0
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid kafka configuration: %w", err)
+	}
+	return config, nil
+}
+
+// validateOptions makes sure each kafka setting has an accepted value,
+// and that the tls files and sasl credentials are present
+func validateOptions(conf *ezconfig.ProducerConfig) error {
+	options := conf.Settings.Kafka
+	if conf.Settings.Retries < 0 {
+		return errors.New("Retries must not be negative")
+	}
+	if _, ok := requiredAcks[options.Acks]; options.Acks != "" && !ok {
+		return fmt.Errorf("Invalid acks %q, expected all, leader or none", options.Acks)
+	}
+	if _, ok := compressionCodecs[options.Compression]; options.Compression != "" && !ok {
+		return fmt.Errorf("Invalid compression %q, expected none, gzip, snappy, lz4 or zstd", options.Compression)
+	}
+	if _, ok := partitioners[options.Partitioner]; options.Partitioner != "" && !ok {
+		return fmt.Errorf("Invalid partitioner %q, expected hash, random or manual", options.Partitioner)
+	}
+	if options.Idempotent && options.Acks != "" && options.Acks != "all" {
+		return fmt.Errorf("Idempotent producers require acks = \"all\", got %q", options.Acks)
+	}
+	if options.Idempotent && conf.Settings.Retries < 1 {
+		return errors.New("Idempotent producers require retries to be at least 1")
+	}
+	sizes := []struct {
+		name  string
+		value int
+	}{
+		{"Max_message_bytes", options.MaxMessageBytes},
+		{"Flush_bytes", options.FlushBytes},
+		{"Flush_messages", options.FlushMessages},
+	}
+	for _, size := range sizes {
+		if size.value < 0 {
+			return fmt.Errorf("%s must not be negative", size.name)
+		}
+	}
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"Flush_frequency", options.FlushFrequency},
+		{"Retry_backoff", options.RetryBackoff},
+		{"Timeout", options.Timeout},
+		{"Dial_timeout", options.DialTimeout},
+		{"Read_timeout", options.ReadTimeout},
+		{"Write_timeout", options.WriteTimeout},
+	}
+	for _, duration := range durations {
+		if duration.value < 0 {
+			return fmt.Errorf("%s must not be negative", duration.name)
+		}
+	}
+	return kafkaauth.Validate(conf.Settings.TLS, conf.Settings.SASL, conf.Log())
+}
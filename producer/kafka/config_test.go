@@ -0,0 +1,112 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/explodes/ezconfig"
+)
+
+// kafkaConfig creates a valid configuration with the given kafka settings
+func kafkaConfig(options ezconfig.KafkaOptions) *ezconfig.ProducerConfig {
+	return &ezconfig.ProducerConfig{
+		Settings: ezconfig.ProducerSettings{Type: kafkaProducerType, Retries: 3, Kafka: options},
+		Hosts:    []ezconfig.ProducerHost{{Host: "localhost", Port: 9092}},
+	}
+}
+
+func TestNewConfig(t *testing.T) {
+	level := 3
+	config, err := newConfig(kafkaConfig(ezconfig.KafkaOptions{
+		ClientID:         "orders",
+		Version:          "2.8.0",
+		Acks:             "all",
+		Compression:      "zstd",
+		CompressionLevel: &level,
+		Idempotent:       true,
+		MaxMessageBytes:  2000000,
+		FlushFrequency:   10 * time.Millisecond,
+		FlushBytes:       65536,
+		FlushMessages:    100,
+		RetryBackoff:     time.Second,
+		Timeout:          5 * time.Second,
+		DialTimeout:      time.Second,
+		ReadTimeout:      2 * time.Second,
+		WriteTimeout:     3 * time.Second,
+	}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	switch {
+	case config.ClientID != "orders":
+		t.Fatalf("Unexpected client id %q", config.ClientID)
+	case config.Version != sarama.V2_8_0_0:
+		t.Fatalf("Unexpected version %v", config.Version)
+	case config.Producer.Compression != sarama.CompressionZSTD || config.Producer.CompressionLevel != 3:
+		t.Fatalf("Unexpected compression %v level %d", config.Producer.Compression, config.Producer.CompressionLevel)
+	case !config.Producer.Idempotent || config.Net.MaxOpenRequests != 1:
+		t.Fatal("Expected an idempotent producer with one request in flight")
+	case config.Producer.MaxMessageBytes != 2000000:
+		t.Fatalf("Unexpected max message bytes %d", config.Producer.MaxMessageBytes)
+	case config.Producer.Flush.Frequency != 10*time.Millisecond || config.Producer.Flush.Bytes != 65536 || config.Producer.Flush.Messages != 100:
+		t.Fatalf("Unexpected flush settings %+v", config.Producer.Flush)
+	case config.Producer.Retry.Max != 3 || config.Producer.Retry.Backoff != time.Second:
+		t.Fatalf("Unexpected retry settings %+v", config.Producer.Retry)
+	case config.Producer.Timeout != 5*time.Second:
+		t.Fatalf("Unexpected timeout %v", config.Producer.Timeout)
+	case config.Net.DialTimeout != time.Second || config.Net.ReadTimeout != 2*time.Second || config.Net.WriteTimeout != 3*time.Second:
+		t.Fatalf("Unexpected network timeouts %v %v %v", config.Net.DialTimeout, config.Net.ReadTimeout, config.Net.WriteTimeout)
+	}
+}
+
+func TestNewConfig_defaults(t *testing.T) {
+	config, err := newConfig(kafkaConfig(ezconfig.KafkaOptions{}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defaults := sarama.NewConfig()
+	if config.Producer.RequiredAcks != sarama.WaitForAll {
+		t.Fatalf("Expected acks from all replicas, got %v", config.Producer.RequiredAcks)
+	}
+	if config.Producer.Timeout != defaults.Producer.Timeout || config.Net.DialTimeout != defaults.Net.DialTimeout {
+		t.Fatal("Expected the default timeouts")
+	}
+	if config.Producer.MaxMessageBytes != defaults.Producer.MaxMessageBytes {
+		t.Fatalf("Expected the default max message bytes, got %d", config.Producer.MaxMessageBytes)
+	}
+}
+
+func TestValidateConfig_kafka(t *testing.T) {
+	level := 42
+	invalid := map[string]ezconfig.KafkaOptions{
+		"version":                {Version: "latest"},
+		"acks":                   {Acks: "some"},
+		"compression":            {Compression: "brotli"},
+		"compression level":      {Compression: "gzip", CompressionLevel: &level},
+		"zstd before 2.1":        {Compression: "zstd", Version: "1.0.0"},
+		"partitioner":            {Partitioner: "round_robin"},
+		"idempotent acks":        {Idempotent: true, Acks: "leader"},
+		"idempotent before 0.11": {Idempotent: true, Version: "0.10.2"},
+		"max message bytes":      {MaxMessageBytes: -1},
+		"flush messages":         {FlushMessages: -1},
+		"timeout":                {Timeout: -time.Second},
+	}
+	for name, options := range invalid {
+		if err := validateConfig(kafkaConfig(options)); err == nil {
+			t.Errorf("Expected an error for an invalid %s", name)
+		}
+	}
+
+	conf := kafkaConfig(ezconfig.KafkaOptions{Idempotent: true})
+	conf.Settings.Retries = 0
+	if err := validateConfig(conf); err == nil {
+		t.Error("Expected an error for an idempotent producer without retries")
+	}
+
+	for partitioner := range partitioners {
+		if err := validateConfig(kafkaConfig(ezconfig.KafkaOptions{Partitioner: partitioner})); err != nil {
+			t.Errorf("Unexpected error for partitioner %s: %v", partitioner, err)
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package kafka
 
 import (
+	"context"
 	"errors"
-	"strconv"
-	"time"
+	"fmt"
+	"sync"
+	"sync/atomic"
 
 	"github.com/Shopify/sarama"
 	"github.com/explodes/ezconfig"
@@ -16,9 +18,12 @@ const (
 	kafkaProducerType = "kafka"
 )
 
+// errClosed is returned when publishing to a closed producer
+var errClosed = errors.New("Producer is closed")
+
 // init registers the init and validation functions with the registry
 func init() {
-	registry.Register(kafkaProducerType, initProducer, validateConfig)
+	registry.RegisterPublisher(kafkaProducerType, initProducer, validateConfig)
 }
 
 // validateConfig makes sure all the required settings are present for the database
@@ -26,47 +31,179 @@ func validateConfig(conf *ezconfig.ProducerConfig) error {
 	if conf.Hosts == nil || len(conf.Hosts) == 0 {
 		return errors.New("Invalid producer configration: No [[producers]] entry in configuration")
 	}
-	return nil
+	if err := conf.Settings.ValidateMode(); err != nil {
+		return err
+	}
+	_, err := newConfig(conf)
+	return err
 }
 
 // initProducer establishes a connection with the given configuration
-func initProducer(conf *ezconfig.ProducerConfig) (producer.Producer, error) {
-	config := sarama.NewConfig()
-	config.Producer.Retry.Max = conf.Settings.Retries
-	config.Producer.RequiredAcks = sarama.WaitForAll
+func initProducer(conf *ezconfig.ProducerConfig) (producer.Publisher, error) {
+	config, err := newConfig(conf)
+	if err != nil {
+		return nil, err
+	}
 	producers := []string{}
 	for _, p := range conf.Hosts {
 		producers = append(producers, p.Address())
 	}
-	p, err := sarama.NewAsyncProducer(producers, config)
+	client, err := sarama.NewClient(producers, config)
 	if err != nil {
 		return nil, err
 	}
-	kafka := kafkaProducer{
-		p:    p,
-		conf: conf,
+	p, err := sarama.NewAsyncProducerFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, err
 	}
-	return &kafka, nil
+	return newKafkaProducer(p, client, conf), nil
 }
 
-// kafkaProducer publishes messages to kafka
+// newKafkaProducer wraps an async producer, draining its results in the background
+func newKafkaProducer(p sarama.AsyncProducer, client sarama.Client, conf *ezconfig.ProducerConfig) *kafkaProducer {
+	kafka := &kafkaProducer{
+		p:      p,
+		client: client,
+		conf:   conf,
+		async:  conf.Settings.Async(),
+	}
+	kafka.drain.Add(2)
+	go kafka.drainSuccesses()
+	go kafka.drainErrors()
+	return kafka
+}
+
+// kafkaProducer publishes messages to kafka.
+// Messages are always sent through an async producer so that concurrent publishers
+// are batched together; in sync mode Publish waits for the acknowledgement.
 type kafkaProducer struct {
-	p    sarama.AsyncProducer
-	conf *ezconfig.ProducerConfig
+	p      sarama.AsyncProducer
+	client sarama.Client
+	conf   *ezconfig.ProducerConfig
+	async  bool
+
+	// handler receives the outcome of messages published in async mode
+	handler atomic.Pointer[producer.DeliveryHandler]
+
+	// mu guards sending to p, which must not happen once it is closed
+	mu     sync.RWMutex
+	closed bool
+
+	// drain waits for the goroutines reading p's results
+	drain sync.WaitGroup
+}
+
+// Publish sends a message to kafka. In sync mode it waits for the message to be
+// acknowledged, in async mode the outcome is sent to the OnDelivery handler.
+func (k *kafkaProducer) Publish(ctx context.Context, msg *producer.Message) error {
+	if k.async {
+		return k.send(ctx, producerMessage(msg, &delivery{}))
+	}
+	_, err := k.Deliver(ctx, msg)
+	return err
+}
+
+// Deliver sends a message to kafka and waits for it to be acknowledged
+func (k *kafkaProducer) Deliver(ctx context.Context, msg *producer.Message) (producer.Delivery, error) {
+	d := &delivery{result: make(chan producer.Delivery, 1)}
+	if err := k.send(ctx, producerMessage(msg, d)); err != nil {
+		return producer.Delivery{Message: msg, Err: err}, err
+	}
+	select {
+	case delivery := <-d.result:
+		return delivery, delivery.Err
+	case <-ctx.Done():
+		err := fmt.Errorf("Message to %s was not acknowledged: %w", msg.Topic, context.Cause(ctx))
+		return producer.Delivery{Message: msg, Err: err}, err
+	}
+}
+
+// OnDelivery sets the handler called with the outcome of messages published in async mode
+func (k *kafkaProducer) OnDelivery(handler producer.DeliveryHandler) {
+	k.handler.Store(&handler)
+}
+
+// send hands a message to the async producer
+func (k *kafkaProducer) send(ctx context.Context, message *sarama.ProducerMessage) error {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.closed {
+		return errClosed
+	}
+	select {
+	case k.p.Input() <- message:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("Message to %s was not sent: %w", message.Topic, context.Cause(ctx))
+	}
 }
 
-// Publish publishes messages to kafka
-func (k kafkaProducer) Publish(topic string, message string) {
-	strTime := strconv.Itoa(int(time.Now().Unix()))
-	k.p.Input() <- &sarama.ProducerMessage{
-		Topic: topic,
-		Key:   sarama.StringEncoder(strTime),
-		Value: sarama.StringEncoder(message),
+// drainSuccesses reports acknowledged messages to their publishers
+func (k *kafkaProducer) drainSuccesses() {
+	defer k.drain.Done()
+	for message := range k.p.Successes() {
+		k.report(message, nil)
 	}
 }
 
-// Close closes the connection to kafka
-func (k kafkaProducer) Close() error {
-	k.p.Close()
-	return nil
+// drainErrors reports failed messages to their publishers
+func (k *kafkaProducer) drainErrors() {
+	defer k.drain.Done()
+	for err := range k.p.Errors() {
+		k.report(err.Msg, fmt.Errorf("Unable to publish to %s: %w", err.Msg.Topic, err.Err))
+	}
+}
+
+// report sends the outcome of a message to the publisher waiting for it,
+// or else to the OnDelivery handler. Failures are logged without a handler.
+func (k *kafkaProducer) report(message *sarama.ProducerMessage, err error) {
+	d, ok := message.Metadata.(*delivery)
+	if !ok {
+		return
+	}
+	delivery := producer.Delivery{Message: d.message, Partition: message.Partition, Offset: message.Offset, Err: err}
+	if d.result != nil {
+		d.result <- delivery
+		return
+	}
+	if handler := k.handler.Load(); handler != nil {
+		(*handler)(delivery)
+		return
+	}
+	if err != nil {
+		k.conf.Log().Error("Unable to publish", "topic", message.Topic, "error", err)
+	}
+}
+
+// Check refreshes the cluster metadata to make sure the brokers can be reached
+func (k *kafkaProducer) Check(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- k.client.RefreshMetadata()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close flushes buffered messages and closes the connection to kafka
+func (k *kafkaProducer) Close() error {
+	k.mu.Lock()
+	if k.closed {
+		k.mu.Unlock()
+		return nil
+	}
+	k.closed = true
+	k.mu.Unlock()
+
+	k.p.AsyncClose()
+	k.drain.Wait()
+	if k.client == nil {
+		return nil
+	}
+	return k.client.Close()
 }
@@ -1,9 +1,14 @@
 package kafka
 
 import (
+	"context"
+	"errors"
 	"reflect"
 	"testing"
 
+	"github.com/Shopify/sarama/mocks"
+	"github.com/explodes/ezconfig"
+	"github.com/explodes/ezconfig/producer"
 	"github.com/explodes/ezconfig/producer/registry"
 )
 
@@ -13,7 +18,7 @@ func TestDetermineFactory(t *testing.T) {
 		t.Fatal("Kafka factory not registered")
 	}
 	sf1 := reflect.ValueOf(initProducer)
-	sf2 := reflect.ValueOf(factory.Init)
+	sf2 := reflect.ValueOf(factory.InitPublisher)
 	if sf1.Pointer() != sf2.Pointer() {
 		t.Fatal("Unexpected init function")
 	}
@@ -23,3 +28,118 @@ func TestDetermineFactory(t *testing.T) {
 		t.Fatal("Unexpected validate function")
 	}
 }
+
+// mockProducer creates a kafkaProducer in the given mode backed by a mock producer
+func mockProducer(t *testing.T, mode string) (*kafkaProducer, *mocks.AsyncProducer) {
+	config := mocks.NewTestConfig()
+	config.Producer.Return.Successes = true
+	mock := mocks.NewAsyncProducer(t, config)
+	conf := &ezconfig.ProducerConfig{Settings: ezconfig.ProducerSettings{Mode: mode}}
+	return newKafkaProducer(mock, nil, conf), mock
+}
+
+func TestValidateConfig_mode(t *testing.T) {
+	conf := &ezconfig.ProducerConfig{Hosts: []ezconfig.ProducerHost{{Host: "localhost", Port: 9092}}}
+	for _, mode := range []string{"", "sync", "async"} {
+		conf.Settings.Mode = mode
+		if err := validateConfig(conf); err != nil {
+			t.Fatalf("Unexpected error for mode %q: %v", mode, err)
+		}
+	}
+	conf.Settings.Mode = "batch"
+	if err := validateConfig(conf); err == nil {
+		t.Fatal("Expected an error for an unknown mode")
+	}
+}
+
+func TestKafkaProducer_Publish(t *testing.T) {
+	k, mock := mockProducer(t, ezconfig.ProducerModeSync)
+	errBroker := errors.New("broker down")
+	mock.ExpectInputAndSucceed()
+	mock.ExpectInputAndFail(errBroker)
+
+	msg := &producer.Message{Topic: "events", Value: []byte("hello")}
+	if err := k.Publish(context.Background(), msg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := k.Publish(context.Background(), msg); !errors.Is(err, errBroker) {
+		t.Fatalf("Expected the broker error, got %v", err)
+	}
+
+	if err := k.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := k.Publish(context.Background(), msg); err != errClosed {
+		t.Fatalf("Expected a closed error, got %v", err)
+	}
+}
+
+func TestKafkaProducer_Deliver(t *testing.T) {
+	k, mock := mockProducer(t, "async")
+	defer k.Close()
+	mock.ExpectInputAndSucceed()
+	mock.ExpectInputAndSucceed()
+
+	msg := &producer.Message{Topic: "billing", Value: []byte("invoice")}
+	first, err := producer.Deliver(context.Background(), k, msg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	second, err := k.Deliver(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if first.Message != msg || second.Offset <= first.Offset {
+		t.Fatalf("Unexpected deliveries %+v and %+v", first, second)
+	}
+}
+
+func TestKafkaProducer_async(t *testing.T) {
+	k, mock := mockProducer(t, "async")
+	defer k.Close()
+	errBroker := errors.New("broker down")
+	mock.ExpectInputAndSucceed()
+	mock.ExpectInputAndFail(errBroker)
+
+	deliveries := make(chan producer.Delivery, 2)
+	k.OnDelivery(func(delivery producer.Delivery) {
+		deliveries <- delivery
+	})
+	for _, value := range []string{"first", "second"} {
+		if err := k.Publish(context.Background(), &producer.Message{Topic: "events", Value: []byte(value)}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	results := map[string]error{}
+	for i := 0; i < 2; i++ {
+		delivery := <-deliveries
+		results[string(delivery.Message.Value)] = delivery.Err
+	}
+	if results["first"] != nil || !errors.Is(results["second"], errBroker) {
+		t.Fatalf("Unexpected results %v", results)
+	}
+}
+
+func TestKafkaProducer_Close_flushes(t *testing.T) {
+	k, mock := mockProducer(t, "async")
+	mock.ExpectInputAndSucceed()
+	deliveries := make(chan producer.Delivery, 1)
+	k.OnDelivery(func(delivery producer.Delivery) {
+		deliveries <- delivery
+	})
+	if err := k.Publish(context.Background(), &producer.Message{Topic: "events"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := k.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	select {
+	case delivery := <-deliveries:
+		if delivery.Err != nil {
+			t.Fatalf("Unexpected error: %v", delivery.Err)
+		}
+	default:
+		t.Fatal("Close returned before the pending message was reported")
+	}
+}
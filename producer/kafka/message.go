@@ -0,0 +1,109 @@
+package kafka
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Shopify/sarama"
+	"github.com/explodes/ezconfig/producer"
+)
+
+// delivery is the Metadata of the messages sent to sarama
+type delivery struct {
+	message *producer.Message
+
+	// result receives the outcome of sending the message when the publisher
+	// waits for it, otherwise the outcome is sent to the DeliveryHandler
+	result chan producer.Delivery
+}
+
+// producerMessage maps a message to a sarama message
+func producerMessage(msg *producer.Message, d *delivery) *sarama.ProducerMessage {
+	d.message = msg
+	message := &sarama.ProducerMessage{
+		Topic:     msg.Topic,
+		Value:     sarama.ByteEncoder(msg.Value),
+		Timestamp: msg.Timestamp,
+		Metadata:  d,
+	}
+	if msg.Key != nil {
+		message.Key = sarama.ByteEncoder(msg.Key)
+	}
+	if len(msg.Headers) > 0 {
+		names := make([]string, 0, len(msg.Headers))
+		for name := range msg.Headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		message.Headers = make([]sarama.RecordHeader, len(names))
+		for i, name := range names {
+			message.Headers[i] = sarama.RecordHeader{Key: []byte(name), Value: msg.Headers[name]}
+		}
+	}
+	return message
+}
+
+// partitioner publishes messages to the partition they name, and otherwise
+// to a partition chosen by its fallback. Without a fallback, messages must name one.
+type partitioner struct {
+	fallback sarama.Partitioner
+}
+
+// newPartitioner is a sarama.PartitionerConstructor for a partitioner
+// that hashes the key of messages
+func newPartitioner(topic string) sarama.Partitioner {
+	return &partitioner{fallback: sarama.NewHashPartitioner(topic)}
+}
+
+// newRandomPartitioner is a sarama.PartitionerConstructor for a partitioner
+// that picks a random partition
+func newRandomPartitioner(topic string) sarama.Partitioner {
+	return &partitioner{fallback: sarama.NewRandomPartitioner(topic)}
+}
+
+// newManualPartitioner is a sarama.PartitionerConstructor for a partitioner
+// that requires messages to name their partition
+func newManualPartitioner(topic string) sarama.Partitioner {
+	return &partitioner{}
+}
+
+// Partition picks the partition of a message
+func (p *partitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if partition := manualPartition(message); partition != nil {
+		if *partition < 0 || *partition >= numPartitions {
+			return -1, fmt.Errorf("Partition %d does not exist, %s has %d partitions", *partition, message.Topic, numPartitions)
+		}
+		return *partition, nil
+	}
+	if p.fallback == nil {
+		return -1, fmt.Errorf("Message to %s has no partition, the manual partitioner requires one", message.Topic)
+	}
+	return p.fallback.Partition(message, numPartitions)
+}
+
+// RequiresConsistency is true, partitions named by messages are indexes into every
+// partition of the topic, not only the ones that are available
+func (p *partitioner) RequiresConsistency() bool {
+	return true
+}
+
+// MessageRequiresConsistency lets messages without a partition go to available
+// partitions only, if the fallback allows it
+func (p *partitioner) MessageRequiresConsistency(message *sarama.ProducerMessage) bool {
+	switch fallback := p.fallback.(type) {
+	case nil:
+		return true
+	case sarama.DynamicConsistencyPartitioner:
+		return manualPartition(message) != nil || fallback.MessageRequiresConsistency(message)
+	default:
+		return manualPartition(message) != nil || fallback.RequiresConsistency()
+	}
+}
+
+// manualPartition returns the partition named by a message, if any
+func manualPartition(message *sarama.ProducerMessage) *int32 {
+	if d, ok := message.Metadata.(*delivery); ok {
+		return d.message.Partition
+	}
+	return nil
+}
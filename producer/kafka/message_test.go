@@ -0,0 +1,90 @@
+package kafka
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/explodes/ezconfig/producer"
+)
+
+func TestProducerMessage(t *testing.T) {
+	timestamp := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	msg := &producer.Message{
+		Topic:     "orders",
+		Key:       []byte("order-42"),
+		Value:     []byte{0, 1, 2},
+		Headers:   map[string][]byte{"trace": []byte("abc"), "content-type": []byte("application/octet-stream")},
+		Timestamp: timestamp,
+	}
+	d := &delivery{}
+	message := producerMessage(msg, d)
+
+	if message.Topic != "orders" || message.Timestamp != timestamp || message.Metadata != d {
+		t.Fatalf("Unexpected message %+v", message)
+	}
+	if key, _ := message.Key.Encode(); string(key) != "order-42" {
+		t.Fatalf("Unexpected key %q", key)
+	}
+	if value, _ := message.Value.Encode(); !reflect.DeepEqual(value, []byte{0, 1, 2}) {
+		t.Fatalf("Unexpected value %v", value)
+	}
+	expected := []sarama.RecordHeader{
+		{Key: []byte("content-type"), Value: []byte("application/octet-stream")},
+		{Key: []byte("trace"), Value: []byte("abc")},
+	}
+	if !reflect.DeepEqual(message.Headers, expected) {
+		t.Fatalf("Unexpected headers %v", message.Headers)
+	}
+
+	if message := producerMessage(&producer.Message{Topic: "orders"}, d); message.Key != nil || message.Headers != nil {
+		t.Fatalf("Expected no key or headers, got %+v", message)
+	}
+}
+
+func TestPartitioner(t *testing.T) {
+	p := newPartitioner("orders")
+
+	msg := (&producer.Message{Topic: "orders"}).WithPartition(3)
+	manual := producerMessage(msg, &delivery{})
+	if partition, err := p.Partition(manual, 4); err != nil || partition != 3 {
+		t.Fatalf("Expected partition 3, got %d (%v)", partition, err)
+	}
+	if _, err := p.Partition(manual, 3); err == nil {
+		t.Fatal("Expected an error for a partition that does not exist")
+	}
+
+	// messages with the same key go to the same partition
+	keyed := func() *sarama.ProducerMessage {
+		return producerMessage(&producer.Message{Topic: "orders", Key: []byte("order-42")}, &delivery{})
+	}
+	first, _ := p.Partition(keyed(), 16)
+	second, _ := p.Partition(keyed(), 16)
+	if first != second {
+		t.Fatalf("Expected the same partition, got %d and %d", first, second)
+	}
+}
+
+func TestPartitioner_manual(t *testing.T) {
+	p := newManualPartitioner("orders")
+
+	msg := (&producer.Message{Topic: "orders"}).WithPartition(1)
+	if partition, err := p.Partition(producerMessage(msg, &delivery{}), 4); err != nil || partition != 1 {
+		t.Fatalf("Expected partition 1, got %d (%v)", partition, err)
+	}
+	if _, err := p.Partition(producerMessage(&producer.Message{Topic: "orders"}, &delivery{}), 4); err == nil {
+		t.Fatal("Expected an error for a message without a partition")
+	}
+}
+
+func TestPartitioner_consistency(t *testing.T) {
+	p := newRandomPartitioner("orders").(sarama.DynamicConsistencyPartitioner)
+	if p.MessageRequiresConsistency(producerMessage(&producer.Message{Topic: "orders"}, &delivery{})) {
+		t.Fatal("Random partitioning should not require consistency")
+	}
+	msg := (&producer.Message{Topic: "orders"}).WithPartition(1)
+	if !p.MessageRequiresConsistency(producerMessage(msg, &delivery{})) {
+		t.Fatal("Messages naming their partition should require consistency")
+	}
+}
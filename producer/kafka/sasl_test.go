@@ -0,0 +1,49 @@
+package kafka
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/explodes/ezconfig"
+)
+
+// saslConfig creates a valid configuration authenticating with the given mechanism
+func saslConfig(mechanism string) *ezconfig.ProducerConfig {
+	conf := kafkaConfig(ezconfig.KafkaOptions{})
+	conf.Settings.TLS = ezconfig.TLSConfig{Enable: true}
+	conf.Settings.SASL = ezconfig.SASLConfig{Mechanism: mechanism, Username: "orders", Password: "hunter2"}
+	return conf
+}
+
+func TestNewConfig_sasl(t *testing.T) {
+	config, err := newConfig(saslConfig("SCRAM-SHA-512"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	sasl := config.Net.SASL
+	if !sasl.Enable || sasl.Mechanism != sarama.SASLTypeSCRAMSHA512 || sasl.User != "orders" || sasl.SCRAMClientGeneratorFunc == nil {
+		t.Fatalf("Unexpected sasl settings: %v %v %v", sasl.Enable, sasl.Mechanism, sasl.User)
+	}
+}
+
+func TestValidateConfig_saslErrors(t *testing.T) {
+	conf := saslConfig("GSSAPI")
+	if err := validateConfig(conf); err == nil {
+		t.Error("Expected an error for an invalid mechanism")
+	}
+}
+
+func TestProducerSASL_redacted(t *testing.T) {
+	conf := saslConfig("PLAIN")
+	builder := &strings.Builder{}
+	logger := slog.New(slog.NewTextHandler(builder, nil))
+	logger.Info("Connecting", "sasl", conf.Settings.SASL)
+	for _, out := range []string{fmt.Sprintf("%+v", conf), fmt.Sprint(conf.Settings.SASL), builder.String()} {
+		if strings.Contains(out, "hunter2") {
+			t.Fatalf("Password was not masked: %s", out)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/explodes/ezconfig"
+)
+
+func TestNewConfig_tls(t *testing.T) {
+	conf := kafkaConfig(ezconfig.KafkaOptions{})
+	conf.Settings.TLS = ezconfig.TLSConfig{Enable: true, ServerName: "broker.internal"}
+	if err := validateConfig(conf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	config, err := newConfig(conf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !config.Net.TLS.Enable || config.Net.TLS.Config == nil || config.Net.TLS.Config.ServerName != "broker.internal" {
+		t.Fatal("Tls settings not applied")
+	}
+}
+
+func TestValidateConfig_tlsErrors(t *testing.T) {
+	conf := kafkaConfig(ezconfig.KafkaOptions{})
+	conf.Settings.TLS = ezconfig.TLSConfig{CAFile: "/missing/ca.pem"}
+	if err := validateConfig(conf); err == nil {
+		t.Error("Expected an error for a missing ca file")
+	}
+}
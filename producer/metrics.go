@@ -0,0 +1,108 @@
+package producer
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/explodes/ezconfig/metrics"
+)
+
+// Instrument wraps a publisher so that it records metrics in the registry:
+//
+//	ezconfig_producer_messages_total  counter of messages published
+//	ezconfig_producer_failures_total  counter of messages that could not be published
+//
+// Every metric is labeled by topic. The outcome of messages published in async mode
+// is counted as it is reported, so the wrapper takes over the OnDelivery handler of
+// the publisher and forwards deliveries to its own. Failures are logged with
+// logger, slog.Default() if nil, until a handler is set.
+func Instrument(p Publisher, registry *metrics.Registry, logger *slog.Logger) Publisher {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	i := &instrumented{
+		p:        p,
+		log:      logger,
+		messages: registry.Counter("ezconfig_producer_messages_total", "Messages published.", "topic"),
+		failures: registry.Counter("ezconfig_producer_failures_total", "Messages that could not be published.", "topic"),
+	}
+	if notifier, ok := p.(Notifier); ok {
+		notifier.OnDelivery(i.delivered)
+	}
+	return i
+}
+
+// instrumented is a Publisher that records metrics
+type instrumented struct {
+	p        Publisher
+	messages *metrics.Counter
+	failures *metrics.Counter
+	log      *slog.Logger
+
+	// handler receives the deliveries reported by p
+	handler atomic.Pointer[DeliveryHandler]
+}
+
+// Publish publishes the message, counting it and any failure
+func (i *instrumented) Publish(ctx context.Context, msg *Message) error {
+	i.messages.Inc(msg.Topic)
+	err := i.p.Publish(ctx, msg)
+	if err != nil {
+		i.failures.Inc(msg.Topic)
+	}
+	return err
+}
+
+// Deliver delivers the message, counting it and any failure
+func (i *instrumented) Deliver(ctx context.Context, msg *Message) (Delivery, error) {
+	i.messages.Inc(msg.Topic)
+	delivery, err := Deliver(ctx, i.p, msg)
+	if err != nil {
+		i.failures.Inc(msg.Topic)
+	}
+	return delivery, err
+}
+
+// OnDelivery sets the handler called with the outcome of messages published in async mode
+func (i *instrumented) OnDelivery(handler DeliveryHandler) {
+	i.handler.Store(&handler)
+}
+
+// delivered counts failed deliveries and forwards them to the handler.
+// Failures are logged without a handler.
+func (i *instrumented) delivered(delivery Delivery) {
+	if delivery.Err != nil {
+		i.failures.Inc(delivery.Message.Topic)
+	}
+	if handler := i.handler.Load(); handler != nil {
+		(*handler)(delivery)
+		return
+	}
+	if delivery.Err != nil {
+		i.log.Error("Unable to publish", "topic", delivery.Message.Topic, "error", delivery.Err)
+	}
+}
+
+// Check checks the publisher if it is a Checker
+func (i *instrumented) Check(ctx context.Context) error {
+	if checker, ok := i.p.(Checker); ok {
+		return checker.Check(ctx)
+	}
+	return nil
+}
+
+// Close closes the publisher
+func (i *instrumented) Close() error {
+	return i.p.Close()
+}
+
+// CloseContext closes the publisher with its CloseContext method, if it has one
+func (i *instrumented) CloseContext(ctx context.Context) error {
+	if closer, ok := i.p.(interface {
+		CloseContext(ctx context.Context) error
+	}); ok {
+		return closer.CloseContext(ctx)
+	}
+	return i.p.Close()
+}
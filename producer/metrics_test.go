@@ -0,0 +1,80 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/explodes/ezconfig/metrics"
+)
+
+var errBroker = errors.New("broker down")
+
+// asyncPublisher reports every message it publishes to its handler
+type asyncPublisher struct {
+	handler DeliveryHandler
+	err     error
+}
+
+func (a *asyncPublisher) Publish(ctx context.Context, msg *Message) error {
+	a.handler(Delivery{Message: msg, Err: a.err})
+	return nil
+}
+
+func (a *asyncPublisher) OnDelivery(handler DeliveryHandler) {
+	a.handler = handler
+}
+
+func (a *asyncPublisher) Close() error {
+	return nil
+}
+
+func TestInstrument(t *testing.T) {
+	registry := metrics.NewRegistry()
+	messages := registry.Counter("ezconfig_producer_messages_total", "", "topic")
+	failures := registry.Counter("ezconfig_producer_failures_total", "", "topic")
+
+	publisher := &asyncPublisher{}
+	p := Instrument(publisher, registry, nil)
+	var delivered []Delivery
+	p.(Notifier).OnDelivery(func(delivery Delivery) {
+		delivered = append(delivered, delivery)
+	})
+
+	msg := &Message{Topic: "events"}
+	p.Publish(context.Background(), msg)
+	publisher.err = errBroker
+	p.Publish(context.Background(), msg)
+
+	if messages.Value("events") != 2 || failures.Value("events") != 1 {
+		t.Fatalf("Expected 2 messages and 1 failure, got %v and %v", messages.Value("events"), failures.Value("events"))
+	}
+	if len(delivered) != 2 || delivered[1].Err != errBroker {
+		t.Fatalf("Deliveries were not forwarded: %v", delivered)
+	}
+}
+
+// failingPublisher fails to publish every message
+type failingPublisher struct{}
+
+func (failingPublisher) Publish(ctx context.Context, msg *Message) error {
+	return errBroker
+}
+
+func (failingPublisher) Close() error {
+	return nil
+}
+
+func TestInstrument_sync(t *testing.T) {
+	registry := metrics.NewRegistry()
+	p := Instrument(failingPublisher{}, registry, nil)
+	if err := p.Publish(context.Background(), &Message{Topic: "events"}); err != errBroker {
+		t.Fatalf("Expected the broker error, got %v", err)
+	}
+	if _, err := Deliver(context.Background(), p, &Message{Topic: "events"}); err != errBroker {
+		t.Fatalf("Expected the broker error, got %v", err)
+	}
+	if failures := registry.Counter("ezconfig_producer_failures_total", "", "topic").Value("events"); failures != 2 {
+		t.Fatalf("Expected 2 failures, got %v", failures)
+	}
+}
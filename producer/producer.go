@@ -1,5 +1,11 @@
 package producer
 
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
 // Producer is capable of publishing messages to the service which backs it
 type Producer interface {
 
@@ -10,3 +16,172 @@ type Producer interface {
 	// the service backing this producer
 	Close() error
 }
+
+// Message is a message to publish
+type Message struct {
+	// Topic is where the message is published to
+	Topic string
+
+	// Key optionally identifies the entity the message is about.
+	// Messages with the same key are published to the same partition, in order.
+	Key []byte
+
+	// Value is the payload of the message
+	Value []byte
+
+	// Headers are optional metadata sent along with the message
+	Headers map[string][]byte
+
+	// Timestamp is optionally when the message was created, the time it is
+	// published if not set
+	Timestamp time.Time
+
+	// Partition optionally forces the partition the message is published to,
+	// for services that have partitions
+	Partition *int32
+}
+
+// WithPartition sets the partition the message is published to
+func (m *Message) WithPartition(partition int32) *Message {
+	m.Partition = &partition
+	return m
+}
+
+// Publisher is capable of publishing messages to the service which backs it,
+// reporting messages that could not be delivered
+type Publisher interface {
+
+	// Publish publishes a message, returning once the service has accepted it.
+	// Publishers in async mode return once the message is queued instead, see Notifier.
+	// It gives up and returns an error wrapping the cause once ctx is done.
+	Publish(ctx context.Context, msg *Message) error
+
+	// Close will flush pending messages and terminate the connection
+	// to the service backing this publisher
+	Close() error
+}
+
+// Delivery is the outcome of publishing a message
+type Delivery struct {
+	Message *Message
+
+	// Partition and Offset are where the message was stored,
+	// for services that have partitions
+	Partition int32
+	Offset    int64
+
+	// Err is the reason the message could not be published
+	Err error
+}
+
+// Deliverer is implemented by publishers that report where messages were stored
+type Deliverer interface {
+
+	// Deliver publishes a message and waits for it to be acknowledged, even in async mode.
+	// It gives up and returns an error wrapping the cause once ctx is done.
+	Deliver(ctx context.Context, msg *Message) (Delivery, error)
+}
+
+// DeliveryHandler receives the outcome of messages published in async mode.
+// It is called from a background goroutine and must not block for long.
+type DeliveryHandler func(delivery Delivery)
+
+// Notifier is implemented by publishers that report the outcome of messages
+// published in async mode
+type Notifier interface {
+
+	// OnDelivery sets the handler called with the outcome of each message
+	// published in async mode. Without one, failures are logged.
+	OnDelivery(handler DeliveryHandler)
+}
+
+// Deliver publishes a message and waits for it to be acknowledged if p is a Deliverer.
+// Otherwise the message is published with Publish, and the Delivery has no partition or offset.
+func Deliver(ctx context.Context, p Publisher, msg *Message) (Delivery, error) {
+	if deliverer, ok := p.(Deliverer); ok {
+		return deliverer.Deliver(ctx, msg)
+	}
+	err := p.Publish(ctx, msg)
+	return Delivery{Message: msg, Err: err}, err
+}
+
+// Checker is implemented by producers that can check the health of their
+// connection to the service which backs them
+type Checker interface {
+
+	// Check returns an error if the service cannot be reached
+	Check(ctx context.Context) error
+}
+
+// FromProducer adapts a Producer to a Publisher.
+// A Producer cannot report failures, so Publish only fails if ctx is already done.
+// Only the topic and value of messages are published.
+func FromProducer(p Producer) Publisher {
+	if adapter, ok := p.(*producerAdapter); ok {
+		return adapter.p
+	}
+	return &publisherAdapter{p: p}
+}
+
+// ToProducer adapts a Publisher to a Producer, which logs the messages that
+// could not be published with logger, slog.Default() if nil
+func ToProducer(p Publisher, logger *slog.Logger) Producer {
+	if adapter, ok := p.(*publisherAdapter); ok {
+		return adapter.p
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &producerAdapter{p: p, log: logger}
+}
+
+// publisherAdapter is a Publisher backed by a Producer
+type publisherAdapter struct {
+	p Producer
+}
+
+// Publish publishes the message with the Producer
+func (a *publisherAdapter) Publish(ctx context.Context, msg *Message) error {
+	if err := ctx.Err(); err != nil {
+		return context.Cause(ctx)
+	}
+	a.p.Publish(msg.Topic, string(msg.Value))
+	return nil
+}
+
+// Close closes the Producer
+func (a *publisherAdapter) Close() error {
+	return a.p.Close()
+}
+
+// publishTimeout bounds how long Producer.Publish waits on a Publisher in sync mode
+const publishTimeout = 10 * time.Second
+
+// producerAdapter is a Producer backed by a Publisher
+type producerAdapter struct {
+	p   Publisher
+	log *slog.Logger
+}
+
+// Publish publishes the message with the Publisher, logging any error.
+// Publishers in sync mode are given publishTimeout to accept it.
+func (a *producerAdapter) Publish(topic string, message string) {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	if err := a.p.Publish(ctx, &Message{Topic: topic, Value: []byte(message)}); err != nil {
+		a.log.Error("Unable to publish", "topic", topic, "error", err)
+	}
+}
+
+// Close closes the Publisher
+func (a *producerAdapter) Close() error {
+	return a.p.Close()
+}
+
+// Check checks the Publisher if it is a Checker
+func (a *producerAdapter) Check(ctx context.Context) error {
+	if checker, ok := a.p.(Checker); ok {
+		return checker.Check(ctx)
+	}
+	return nil
+}
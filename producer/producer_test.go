@@ -0,0 +1,66 @@
+package producer
+
+import (
+	"context"
+	"testing"
+)
+
+// recordingProducer records the messages published with the original interface
+type recordingProducer struct {
+	published []string
+}
+
+func (r *recordingProducer) Publish(topic string, message string) {
+	r.published = append(r.published, topic+":"+message)
+}
+
+func (r *recordingProducer) Close() error {
+	return nil
+}
+
+func TestFromProducer(t *testing.T) {
+	recorder := &recordingProducer{}
+	publisher := FromProducer(recorder)
+	if err := publisher.Publish(context.Background(), &Message{Topic: "events", Value: []byte("hello")}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := publisher.Publish(ctx, &Message{Topic: "events"}); err != context.Canceled {
+		t.Fatalf("Expected a cancellation error, got %v", err)
+	}
+	if len(recorder.published) != 1 || recorder.published[0] != "events:hello" {
+		t.Fatalf("Unexpected messages %v", recorder.published)
+	}
+
+	// adapting back returns the original producer
+	if ToProducer(publisher, nil) != Producer(recorder) {
+		t.Fatal("Expected the original producer")
+	}
+}
+
+// recordingPublisher records the messages published with the Publisher interface
+type recordingPublisher struct {
+	published []*Message
+}
+
+func (r *recordingPublisher) Publish(ctx context.Context, msg *Message) error {
+	r.published = append(r.published, msg)
+	return nil
+}
+
+func (r *recordingPublisher) Close() error {
+	return nil
+}
+
+func TestToProducer(t *testing.T) {
+	recorder := &recordingPublisher{}
+	p := ToProducer(recorder, nil)
+	p.Publish("events", "hello")
+	if len(recorder.published) != 1 || recorder.published[0].Topic != "events" || string(recorder.published[0].Value) != "hello" {
+		t.Fatalf("Unexpected messages %v", recorder.published)
+	}
+	if FromProducer(p) != Publisher(recorder) {
+		t.Fatal("Expected the original publisher")
+	}
+}
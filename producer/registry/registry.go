@@ -9,14 +9,20 @@ import (
 // it into a Producer
 type InitFunc func(conf *ezconfig.ProducerConfig) (producer.Producer, error)
 
+// PublisherInitFunc is a function that takes producer configuration and turns
+// it into a Publisher
+type PublisherInitFunc func(conf *ezconfig.ProducerConfig) (producer.Publisher, error)
+
 // ValidateFunc is a function that checks configuration to see if it
 // works for a given producer type
 type ValidateFunc func(conf *ezconfig.ProducerConfig) error
 
-// ProducerFactory holds the requirements to validate and connect to a producer
+// ProducerFactory holds the requirements to validate and connect to a producer.
+// Init and InitPublisher create the same producer, adapted to each interface.
 type ProducerFactory struct {
-	Init     InitFunc
-	Validate ValidateFunc
+	Init          InitFunc
+	InitPublisher PublisherInitFunc
+	Validate      ValidateFunc
 }
 
 // registry holds the registered producer types
@@ -27,16 +33,47 @@ func Register(producerType string, init InitFunc, validate ValidateFunc) {
 	if init == nil {
 		panic("ezconfig: init function is nil")
 	}
-	if validate == nil {
+	register(producerType, &ProducerFactory{
+		Init: init,
+		InitPublisher: func(conf *ezconfig.ProducerConfig) (producer.Publisher, error) {
+			p, err := init(conf)
+			if err != nil {
+				return nil, err
+			}
+			return producer.FromProducer(p), nil
+		},
+		Validate: validate,
+	})
+}
+
+// RegisterPublisher registers init and validation functions for a given producer type
+// that creates a Publisher
+func RegisterPublisher(producerType string, init PublisherInitFunc, validate ValidateFunc) {
+	if init == nil {
+		panic("ezconfig: init function is nil")
+	}
+	register(producerType, &ProducerFactory{
+		Init: func(conf *ezconfig.ProducerConfig) (producer.Producer, error) {
+			p, err := init(conf)
+			if err != nil {
+				return nil, err
+			}
+			return producer.ToProducer(p, conf.Log()), nil
+		},
+		InitPublisher: init,
+		Validate:      validate,
+	})
+}
+
+// register adds a factory for a producer type
+func register(producerType string, factory *ProducerFactory) {
+	if factory.Validate == nil {
 		panic("ezconfig: validate function is nil")
 	}
 	if _, dup := registry[producerType]; dup {
 		panic("ezconfig: Register called twice for type " + producerType)
 	}
-	registry[producerType] = &ProducerFactory{
-		Init:     init,
-		Validate: validate,
-	}
+	registry[producerType] = factory
 }
 
 // Get acquires the registered producer type and returns its related init and validation functions
@@ -3,6 +3,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"flag"
@@ -13,7 +14,11 @@ import (
 
 	"github.com/explodes/ezconfig"
 	"github.com/explodes/ezconfig/backoff"
+	"github.com/explodes/ezconfig/consumer"
+	_ "github.com/explodes/ezconfig/consumer/dummy"
 	_ "github.com/explodes/ezconfig/db/pg"
+	"github.com/explodes/ezconfig/health"
+	"github.com/explodes/ezconfig/lifecycle"
 	"github.com/explodes/ezconfig/opener"
 	"github.com/explodes/ezconfig/producer"
 	_ "github.com/explodes/ezconfig/producer/dummy"
@@ -39,9 +44,11 @@ type ServerConfig struct {
 }
 
 // Config is the outermost configuration spec.
-// Embedded is a ProducerConfig and DbConfig for connecting to a producer and database.
+// Embedded is a ProducerConfig, ConsumerConfig and DbConfig for connecting to a producer,
+// consumer and database.
 type Config struct {
 	ezconfig.ProducerConfig
+	ezconfig.ConsumerConfig
 	ezconfig.DbConfig
 	Server ServerConfig
 }
@@ -50,7 +57,9 @@ type Config struct {
 type App struct {
 	config   *Config
 	db       *sql.DB
-	producer producer.Producer
+	producer producer.Publisher
+	consumer consumer.Consumer
+	health   *health.Handler
 }
 
 func init() {
@@ -65,35 +74,51 @@ func appWrap(f func(app *App, req *jsonserv.Request, res *jsonserv.Response)) js
 	}
 }
 
-// readConfig reads configuration and initializes our App's context
-func readConfig() *App {
+// readConfig reads configuration and initializes our App's context.
+// The connections are closed by the lifecycle manager when we shut down.
+func readConfig(manager *lifecycle.Manager) *App {
 	config := &Config{}
 	err := ezconfig.ReadConfig(*configFilePath, config)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// connect to our producer and database with an exponential backoff strategy
+	// stop retrying if we are asked to shut down while connecting
+	ctx, stop := manager.Context(context.Background())
+	defer stop()
+
+	// connect to our producer, consumer and database with an exponential backoff strategy
 	connections, err := opener.New().
 		WithRetry(connectionRetries, backoff.Exponential(10*time.Millisecond, 1*time.Second, 2)).
 		WithDatabase(&config.DbConfig).
 		WithProducer(&config.ProducerConfig).
-		Connect()
+		WithConsumer(&config.ConsumerConfig).
+		ConnectContext(ctx)
 
 	if err != nil {
 		log.Fatalf("Unable to connect: %v", err)
 	}
+	manager.OnClose("connections", connections)
+
+	// report that we are not ready as soon as we start shutting down
+	checker := health.New(connections)
+	manager.OnShutdown("health", checker.Shutdown)
 
 	return &App{
 		config:   config,
 		db:       connections.DB,
-		producer: connections.Producer,
+		producer: connections.Publisher,
+		consumer: connections.Consumer,
+		health:   checker,
 	}
 }
 
 func main() {
+	// shut down gracefully on SIGINT and SIGTERM
+	manager := lifecycle.New().WithGracePeriod(10 * time.Second)
+
 	// read our configuration
-	app := readConfig()
+	app := readConfig(manager)
 	config := app.config
 
 	bind := fmt.Sprintf("%s:%d", config.Server.Host, config.Server.Port)
@@ -104,20 +129,42 @@ func main() {
 		AddMiddleware(jsonserv.NewMaxRequestSizeMiddleware(config.Server.MaxRequestSize)).
 		AddMiddleware(jsonserv.NewDebugFlagMiddleware(config.Server.Debug)).
 		AddRoute(http.MethodGet, "Index", "/", appWrap(indexView)).
-		AddRoute(http.MethodGet, "Error", "/error", appWrap(errorView))
+		AddRoute(http.MethodGet, "Error", "/error", appWrap(errorView)).
+		AddRoute(http.MethodGet, "Live", "/healthz", appWrap(liveView)).
+		AddRoute(http.MethodGet, "Ready", "/readyz", appWrap(readyView))
 
 	// if verbose logging is enabled, log requests as well
 	if config.Server.LogRequests > 0 {
 		server.AddMiddleware(jsonserv.NewLoggingMiddleware(config.Server.LogRequests > 1))
 	}
 
-	if err := server.Serve(); err != nil {
-		log.Fatal(err)
+	go func() {
+		if err := server.Serve(); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	// log the messages published to the test topic until the consumer is closed.
+	// With the kafka producer and consumer types these are the messages indexView
+	// publishes, the dummy consumer of local.conf only receives consumer/dummy.Send.
+	go func() {
+		err := app.consumer.Subscribe(context.Background(), []string{"test"}, logMessage)
+		log.Printf("Stopped consuming: %v", err)
+	}()
+
+	// flush the producer and close the database before exiting
+	if err := manager.Wait(context.Background()); err != nil {
+		log.Fatalf("Unable to shut down cleanly: %v", err)
 	}
 }
 
 // indexView is a view demonstrating that we have a database and producer at the point of entry
 func indexView(app *App, req *jsonserv.Request, res *jsonserv.Response) {
+	msg := &producer.Message{Topic: "test", Value: []byte("hello_world")}
+	if err := app.producer.Publish(context.Background(), msg); err != nil {
+		res.Error(err)
+		return
+	}
 	res.Ok(map[string]interface{}{
 		"hello":    "Hello, World!",
 		"world":    true,
@@ -125,10 +172,32 @@ func indexView(app *App, req *jsonserv.Request, res *jsonserv.Response) {
 		"producer": app.producer,
 		"request":  req.String(),
 	})
-	app.producer.Publish("test", "hello_world")
+}
+
+// logMessage is a consumer.Handler that logs the messages it receives
+func logMessage(ctx context.Context, msg *consumer.Message) error {
+	log.Printf("Received %q from %s at offset %d", msg.Value, msg.Topic, msg.Offset)
+	return nil
 }
 
 // errorView is a view that simply returns a 500
 func errorView(app *App, req *jsonserv.Request, res *jsonserv.Response) {
 	res.Error(errors.New("failed!!!"))
 }
+
+// liveView reports that we are serving requests
+func liveView(app *App, req *jsonserv.Request, res *jsonserv.Response) {
+	res.Ok(map[string]interface{}{
+		"status": health.StatusOK,
+	})
+}
+
+// readyView reports the health of our database and producer
+func readyView(app *App, req *jsonserv.Request, res *jsonserv.Response) {
+	report := app.health.Report(context.Background())
+	if report.Status == health.StatusUnavailable {
+		res.Error(fmt.Errorf("not ready: %+v", report.Resources))
+		return
+	}
+	res.Ok(report)
+}